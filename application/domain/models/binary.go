@@ -0,0 +1,60 @@
+package models
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/google/uuid"
+)
+
+const binarySubtypeUUID = 4
+
+// Binary is a BSON binary value such as the collection uuid (ui) or a session
+// id. It accepts both the {"Subtype": ..., "Data": ...} form written by the
+// Go driver and the extended JSON {"$binary": {"base64": ..., "subType": ...}}.
+type Binary struct {
+	Subtype byte   `json:"Subtype"`
+	Data    []byte `json:"Data"`
+}
+
+func (b *Binary) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Subtype  byte   `json:"Subtype"`
+		Data     []byte `json:"Data"`
+		Extended *struct {
+			Base64  []byte `json:"base64"`
+			SubType string `json:"subType"`
+		} `json:"$binary"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return fmt.Errorf("invalid binary value: %v", err)
+	}
+	if raw.Extended == nil {
+		b.Subtype, b.Data = raw.Subtype, raw.Data
+		return nil
+	}
+	subtype, err := strconv.ParseUint(raw.Extended.SubType, 16, 8)
+	if err != nil {
+		return fmt.Errorf("invalid binary subtype: %v", err)
+	}
+	b.Subtype, b.Data = byte(subtype), raw.Extended.Base64
+	return nil
+}
+
+// String formats UUIDs in their canonical form and other values as hex.
+func (b Binary) String() string {
+	if b.Subtype == binarySubtypeUUID {
+		if id, err := uuid.FromBytes(b.Data); err == nil {
+			return id.String()
+		}
+	}
+	return hex.EncodeToString(b.Data)
+}
+
+// SessionID is the logical session (lsid) an operation was executed in.
+type SessionID struct {
+	ID  Binary  `json:"id"`
+	UID *Binary `json:"uid,omitempty"`
+}
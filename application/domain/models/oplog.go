@@ -5,10 +5,23 @@ type OpLog struct {
 	Namespace string         `bson:"ns" json:"ns"`
 	Data      map[string]any `bson:"o" json:"o"`
 	O2        *O2Field       `bson:"o2,omitempty" json:"o2,omitempty"`
+	Timestamp Timestamp      `bson:"ts" json:"ts"`
+	Wall      WallTime       `bson:"wall,omitempty" json:"wall,omitempty"`
+	TxnNumber *int64         `bson:"txnNumber,omitempty" json:"txnNumber,omitempty"`
+	Term      *int64         `bson:"t,omitempty" json:"t,omitempty"`
+	UI        *Binary        `bson:"ui,omitempty" json:"ui,omitempty"`
+	LSID      *SessionID     `bson:"lsid,omitempty" json:"lsid,omitempty"`
+	// ResumeToken and PreImage are only set for entries built from change
+	// stream events. PreImage is the document before the change, when the
+	// collection records pre-images.
+	ResumeToken map[string]any `bson:"resumeToken,omitempty" json:"resumeToken,omitempty"`
+	PreImage    map[string]any `bson:"preImage,omitempty" json:"preImage,omitempty"`
+	// Snapshot is only set for the synthetic inserts of an initial snapshot.
+	Snapshot *SnapshotPosition `bson:"snapshot,omitempty" json:"snapshot,omitempty"`
 }
 
 type O2Field struct {
-	ID string `bson:"_id" json:"_id"`
+	ID any `bson:"_id" json:"_id"`
 }
 
 const (
@@ -0,0 +1,14 @@
+package models
+
+import "encoding/json"
+
+// SnapshotPosition is the progress of the initial snapshot of one collection,
+// as of the synthetic insert carrying it.
+type SnapshotPosition struct {
+	Namespace string `json:"ns"`
+	// LastID is the _id of the inserted document as extended JSON
+	// {"_id": ...}, so the scan can resume from it with its BSON type.
+	LastID json.RawMessage `json:"lastId,omitempty"`
+	// Done marks the last document of the collection.
+	Done bool `json:"done,omitempty"`
+}
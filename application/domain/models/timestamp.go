@@ -0,0 +1,124 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Timestamp is the oplog ts field: seconds since the epoch and an ordinal
+// within the second. It accepts both the {"T": ..., "I": ...} form written by
+// the Go driver and the extended JSON {"$timestamp": {"t": ..., "i": ...}}.
+type Timestamp struct {
+	T uint32 `json:"T"`
+	I uint32 `json:"I"`
+}
+
+func (ts *Timestamp) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		T        *uint32 `json:"T"`
+		I        *uint32 `json:"I"`
+		Extended *struct {
+			T uint32 `json:"t"`
+			I uint32 `json:"i"`
+		} `json:"$timestamp"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return fmt.Errorf("invalid oplog timestamp: %v", err)
+	}
+	switch {
+	case raw.Extended != nil:
+		ts.T, ts.I = raw.Extended.T, raw.Extended.I
+	case raw.T != nil:
+		ts.T = *raw.T
+		if raw.I != nil {
+			ts.I = *raw.I
+		}
+	}
+	return nil
+}
+
+func (ts Timestamp) IsZero() bool {
+	return ts.T == 0 && ts.I == 0
+}
+
+// Int64 packs the timestamp into a single integer that orders like the
+// timestamp itself, as stored in BIGINT columns.
+func (ts Timestamp) Int64() int64 {
+	return int64(ts.T)<<32 | int64(ts.I)
+}
+
+// Before reports whether ts is older than other.
+func (ts Timestamp) Before(other Timestamp) bool {
+	return ts.T < other.T || (ts.T == other.T && ts.I < other.I)
+}
+
+// ParseTimestamp parses a timestamp given either as "T:I" or as an RFC 3339
+// wall-clock time, which is the first timestamp of that second.
+func ParseTimestamp(value string) (Timestamp, error) {
+	if t, i, ok := strings.Cut(value, ":"); ok && !strings.Contains(i, ":") {
+		seconds, errT := strconv.ParseUint(t, 10, 32)
+		ordinal, errI := strconv.ParseUint(i, 10, 32)
+		if errT == nil && errI == nil {
+			return Timestamp{T: uint32(seconds), I: uint32(ordinal)}, nil
+		}
+	}
+	wall, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return Timestamp{}, fmt.Errorf("invalid timestamp %q: expected T:I or an RFC 3339 time", value)
+	}
+	if wall.Unix() < 0 || wall.Unix() > math.MaxUint32 {
+		return Timestamp{}, fmt.Errorf("timestamp %q out of range", value)
+	}
+	return Timestamp{T: uint32(wall.Unix())}, nil
+}
+
+// WallTime is the oplog wall field. It accepts an RFC 3339 string, as the Go
+// driver writes it, and the extended JSON {"$date": ...} with either an
+// RFC 3339 string or milliseconds since the epoch, plain or as
+// {"$numberLong": ...}.
+type WallTime struct {
+	time.Time
+}
+
+func (w *WallTime) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+	var raw struct {
+		Date json.RawMessage `json:"$date"`
+	}
+	if err := json.Unmarshal(data, &raw); err == nil && raw.Date != nil {
+		return w.unmarshalDate(raw.Date)
+	}
+	if err := w.Time.UnmarshalJSON(data); err != nil {
+		return fmt.Errorf("invalid wall time: %v", err)
+	}
+	return nil
+}
+
+func (w *WallTime) unmarshalDate(data json.RawMessage) error {
+	var millis json.Number
+	var long struct {
+		NumberLong json.Number `json:"$numberLong"`
+	}
+	switch {
+	case json.Unmarshal(data, &millis) == nil:
+	case json.Unmarshal(data, &long) == nil && long.NumberLong != "":
+		millis = long.NumberLong
+	default:
+		if err := w.Time.UnmarshalJSON(data); err != nil {
+			return fmt.Errorf("invalid wall time: %v", err)
+		}
+		return nil
+	}
+	ms, err := millis.Int64()
+	if err != nil {
+		return fmt.Errorf("invalid wall time: %v", err)
+	}
+	w.Time = time.UnixMilli(ms).UTC()
+	return nil
+}
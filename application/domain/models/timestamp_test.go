@@ -0,0 +1,95 @@
+package models
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWallTimeUnmarshalJSON(t *testing.T) {
+	expected := time.Date(2023, 6, 2, 6, 28, 49, 457000000, time.UTC)
+	testCases := []struct {
+		name        string
+		input       string
+		expected    time.Time
+		expectedErr bool
+	}{
+		{name: "RFC 3339", input: `"2023-06-02T11:58:49.457+05:30"`, expected: expected},
+		{name: "extended JSON relaxed", input: `{"$date": "2023-06-02T06:28:49.457Z"}`, expected: expected},
+		{name: "extended JSON canonical", input: `{"$date": {"$numberLong": "1685687329457"}}`, expected: expected},
+		{name: "extended JSON milliseconds", input: `{"$date": 1685687329457}`, expected: expected},
+		{name: "null", input: `null`},
+		{name: "malformed string", input: `"yesterday"`, expectedErr: true},
+		{name: "malformed date", input: `{"$date": {"$numberLong": "soon"}}`, expectedErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var wall WallTime
+			err := json.Unmarshal([]byte(tc.input), &wall)
+			if tc.expectedErr {
+				if err == nil {
+					t.Fatalf("Expected an error, got %v", wall)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Did not expect an error, but got: %v", err)
+			}
+			if !wall.Equal(tc.expected) {
+				t.Errorf("Expected %v, got %v", tc.expected, wall.Time)
+			}
+		})
+	}
+}
+
+func TestOpLogWithExtendedJSONWall(t *testing.T) {
+	var opLog OpLog
+	input := `{"op": "i", "ns": "test.student", "o": {"_id": "1"}, "wall": {"$date": {"$numberLong": "1685687329457"}}}`
+	if err := json.Unmarshal([]byte(input), &opLog); err != nil {
+		t.Fatalf("Did not expect an error, but got: %v", err)
+	}
+	if opLog.Wall.UnixMilli() != 1685687329457 {
+		t.Errorf("Expected wall 1685687329457, got %d", opLog.Wall.UnixMilli())
+	}
+}
+
+func TestParseTimestamp(t *testing.T) {
+	testCases := []struct {
+		name        string
+		input       string
+		expected    Timestamp
+		expectedErr string
+	}{
+		{name: "T:I", input: "1685687329:2", expected: Timestamp{T: 1685687329, I: 2}},
+		{name: "T:I at the maximum", input: "4294967295:4294967295", expected: Timestamp{T: 4294967295, I: 4294967295}},
+		{name: "RFC 3339 is the first timestamp of its second", input: "2023-06-02T06:28:49Z", expected: Timestamp{T: 1685687329}},
+		{name: "RFC 3339 with an offset", input: "2023-06-02T11:58:49+05:30", expected: Timestamp{T: 1685687329}},
+		{name: "RFC 3339 fractions are truncated", input: "2023-06-02T06:28:49.999Z", expected: Timestamp{T: 1685687329}},
+		{name: "T:I out of range", input: "4294967296:1", expectedErr: `invalid timestamp "4294967296:1"`},
+		{name: "negative T:I", input: "-1:0", expectedErr: `invalid timestamp "-1:0"`},
+		{name: "RFC 3339 before the epoch", input: "1969-12-31T23:59:59Z", expectedErr: `timestamp "1969-12-31T23:59:59Z" out of range`},
+		{name: "RFC 3339 after 2106", input: "2106-02-07T06:28:16Z", expectedErr: `timestamp "2106-02-07T06:28:16Z" out of range`},
+		{name: "malformed", input: "yesterday", expectedErr: `invalid timestamp "yesterday": expected T:I or an RFC 3339 time`},
+		{name: "empty", input: "", expectedErr: `invalid timestamp ""`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			actual, err := ParseTimestamp(tc.input)
+			if tc.expectedErr != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), tc.expectedErr) {
+					t.Errorf("Expected error '%v', but got '%v'", tc.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Did not expect an error, but got: %v", err)
+			}
+			if actual != tc.expected {
+				t.Errorf("Expected %+v, got %+v", tc.expected, actual)
+			}
+		})
+	}
+}
@@ -7,19 +7,51 @@ import (
 type ParserService interface {
 	Parse(oplogJSON string) ([]string, error)
 
-	ProcessOpLog(opLog models.OpLog) ([]string, error)
+	// ProcessOpLog returns the statements for opLog. guards holds, per
+	// statement, the query selecting the target row of a statement that
+	// only applies when the row was last changed by an older oplog entry,
+	// or is empty when no statement is guarded.
+	ProcessOpLog(opLog models.OpLog) (statements, guards []string, err error)
+
+	// StageSchema ends the unit of the entries processed since the last
+	// call to StageSchema or DiscardSchema. Their schema changes stay
+	// visible to the entries processed next, and are recorded by the schema
+	// tracker once the returned function is called, when their statements
+	// were applied.
+	StageSchema() func() error
+
+	// DiscardSchema drops the schema changes of the entries processed since
+	// the last call to StageSchema or DiscardSchema, whose statements are
+	// not applied.
+	DiscardSchema()
 }
 
+// SchemaTracker remembers which schemas, tables and columns have already been
+// created so the parser only emits the DDL that is still missing. Columns are
+// tracked with their SQL type, along with the mapping from document field
+// names to SQL column names. Mutating methods return an error because
+// implementations may persist their state.
 type SchemaTracker interface {
 	IsDDLGenerated(namespace string) bool
 
-	MarkDDLGenerated(namespace string)
+	MarkDDLGenerated(namespace string) error
 
 	GetKnownColumns(namespace string) map[string]bool
 
-	InitializeColumnTracker(namespace string, data map[string]any)
+	// GetColumnTypes returns the SQL type of every known column.
+	GetColumnTypes(namespace string) map[string]string
 
-	UpdateColumnsTracker(namespace string, newFields map[string]any)
+	InitializeColumnTracker(namespace string, columns map[string]string) error
+
+	UpdateColumnsTracker(namespace string, newColumns map[string]string) error
+
+	MarkColumnsNullable(namespace string, columns []string) error
+
+	GetColumnNames(namespace string) map[string]string
+
+	SetColumnNames(namespace string, names map[string]string) error
+
+	Close() error
 }
 
 type UUIDGenerator func() string
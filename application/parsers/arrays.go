@@ -0,0 +1,96 @@
+package parsers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+)
+
+const (
+	MixedArrayJSONB       = "jsonb"
+	MixedArrayChildValues = "child-values"
+
+	fieldValue = "value"
+)
+
+type arrayKind int
+
+const (
+	arrayEmpty arrayKind = iota
+	arrayObjects
+	arrayScalars
+	arrayMixed
+)
+
+func (k arrayKind) String() string {
+	switch k {
+	case arrayEmpty:
+		return "empty"
+	case arrayObjects:
+		return "objects"
+	case arrayScalars:
+		return "scalars"
+	default:
+		return "mixed"
+	}
+}
+
+// jsonValue marks a value that is stored as a JSONB column instead of being
+// split into child rows.
+type jsonValue struct {
+	value any
+}
+
+// classifyArray looks at every element, not only the first one, to decide
+// how an array is stored.
+func classifyArray(items []any) arrayKind {
+	if len(items) == 0 {
+		return arrayEmpty
+	}
+
+	objects := 0
+	for _, item := range items {
+		if _, ok := item.(map[string]any); ok {
+			objects++
+		}
+	}
+	switch objects {
+	case len(items):
+		return arrayObjects
+	case 0:
+		return arrayScalars
+	default:
+		return arrayMixed
+	}
+}
+
+// childValues wraps every element of a mixed array in a single "value" column
+// so it can be stored as child rows. Documents are stored as their JSON text.
+func childValues(items []any) ([]any, error) {
+	rows := make([]any, 0, len(items))
+	for _, item := range items {
+		value := item
+		if obj, ok := item.(map[string]any); ok {
+			encoded, err := json.Marshal(obj)
+			if err != nil {
+				return nil, err
+			}
+			value = string(encoded)
+		} else if item != nil {
+			value = fmt.Sprintf("%v", item)
+		}
+		rows = append(rows, map[string]any{fieldValue: value})
+	}
+	return rows, nil
+}
+
+// logArrayDecision logs how an array field is stored, once per field and
+// decision.
+func (op *opLogParser) logArrayDecision(namespace, field string, kind arrayKind, decision string) {
+	key := fmt.Sprintf("%s.%s:%s", namespace, field, decision)
+	if op.loggedArrayFields[key] {
+		return
+	}
+	op.loggedArrayFields[key] = true
+	log.Printf("Array field %s.%s: %s array, %s", namespace, field, kind, decision)
+}
@@ -0,0 +1,22 @@
+package parsers
+
+import (
+	"fmt"
+
+	googleuuid "github.com/google/uuid"
+)
+
+var childIDNamespace = googleuuid.MustParse("5b0f3c2e-8f7a-4d1e-9c6b-2a4e7d9f1c30")
+
+// childID returns the _id of a child row. With the deterministic strategy it
+// is a UUIDv5 of the child table, the parent _id and the array index, so
+// replays produce the same ids and updates can address a specific child.
+// Upserted rows use it unless configured otherwise, a replayed child row then
+// conflicts with the existing one instead of duplicating it.
+func (op *opLogParser) childID(childTable, parentID string, index int, upsert bool) string {
+	if !op.config.deterministicChildIDs(upsert) {
+		return op.uuidGenerator()
+	}
+	name := fmt.Sprintf("%s/%s/%d", childTable, parentID, index)
+	return googleuuid.NewSHA1(childIDNamespace, []byte(name)).String()
+}
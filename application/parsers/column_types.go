@@ -0,0 +1,101 @@
+package parsers
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Type families of target columns, as far as the literals the parser emits
+// are concerned.
+const (
+	familyOther = iota
+	familyText
+	familyNumeric
+	familyBoolean
+	familyJSON
+)
+
+// booleanLiterals are the strings PostgreSQL accepts as boolean input.
+var booleanLiterals = map[string]bool{
+	"t": true, "true": true, "y": true, "yes": true, "on": true, "1": true,
+	"f": true, "false": true, "n": true, "no": true, "off": true, "0": true,
+}
+
+// columnFamily classifies a tracked column type, whether the parser created
+// the column or it was introspected from the target.
+func columnFamily(sqlType string) int {
+	sqlType = strings.ToUpper(sqlType)
+	switch {
+	case strings.HasPrefix(sqlType, "VARCHAR"), strings.HasPrefix(sqlType, "CHAR"), sqlType == "TEXT":
+		return familyText
+	case strings.HasPrefix(sqlType, "NUMERIC"), strings.HasPrefix(sqlType, "DECIMAL"),
+		sqlType == "FLOAT", sqlType == "DOUBLE PRECISION", sqlType == "REAL",
+		sqlType == "INTEGER", sqlType == "BIGINT", sqlType == "SMALLINT":
+		return familyNumeric
+	case sqlType == "BOOLEAN":
+		return familyBoolean
+	case sqlType == "JSON", sqlType == "JSONB":
+		return familyJSON
+	default:
+		return familyOther
+	}
+}
+
+// columnTypeConflict reports whether a column of sqlType rejects the literal
+// of value. Text columns take any literal, string literals are accepted when
+// their content parses as the column type. Unknown column types are not
+// checked.
+func columnTypeConflict(sqlType string, value any) bool {
+	if id, ok := value.(idValue); ok {
+		value = id.value
+	}
+	family := columnFamily(sqlType)
+	if family == familyText || family == familyOther {
+		return false
+	}
+
+	switch val := value.(type) {
+	case nil, sqlExpr:
+		return false
+	case string:
+		switch family {
+		case familyNumeric:
+			_, err := strconv.ParseFloat(strings.TrimSpace(val), 64)
+			return err != nil
+		case familyBoolean:
+			return !booleanLiterals[strings.ToLower(strings.TrimSpace(val))]
+		default:
+			return !json.Valid([]byte(val))
+		}
+	case bool:
+		return family != familyBoolean
+	case jsonValue:
+		return family != familyJSON
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+		return family != familyNumeric
+	default:
+		return false
+	}
+}
+
+// checkColumnTypes returns an error when a value of data does not fit the
+// type its column has in the target, which would make the statement fail
+// there.
+func (op *opLogParser) checkColumnTypes(namespace string, data map[string]any) error {
+	columnTypes := op.tracker.GetColumnTypes(namespace)
+	var conflicts []string
+	for col, value := range data {
+		sqlType, ok := columnTypes[col]
+		if ok && columnTypeConflict(sqlType, value) {
+			conflicts = append(conflicts, fmt.Sprintf("%s %s: %v", col, sqlType, value))
+		}
+	}
+	if len(conflicts) == 0 {
+		return nil
+	}
+	sort.Strings(conflicts)
+	return fmt.Errorf("type conflict in %s: %s", namespace, strings.Join(conflicts, ", "))
+}
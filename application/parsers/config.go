@@ -0,0 +1,118 @@
+package parsers
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+const (
+	ChildIDRandom        = "random"
+	ChildIDDeterministic = "deterministic"
+
+	HistoryBoth = "both"
+	HistoryOnly = "only"
+)
+
+// Config holds the optional parser settings. The zero value keeps the default
+// behaviour of NewParser.
+type Config struct {
+	Namespaces map[string]NamespaceConfig `json:"namespaces,omitempty"`
+	HashSalt   string                     `json:"hash_salt,omitempty"`
+	// Upsert makes the generated SQL safe to replay: DDL uses IF NOT EXISTS
+	// and inserts update the existing row on an _id conflict.
+	Upsert bool `json:"upsert,omitempty"`
+	// ChildIDStrategy selects how child row ids are generated: "random"
+	// or "deterministic". It defaults to deterministic for upserted rows,
+	// with Upsert or SoftDelete, which reject random ids since replayed
+	// child rows would never conflict, and to random otherwise.
+	ChildIDStrategy string `json:"child_id_strategy,omitempty"`
+	// ArrayPositionColumn adds a _position column with the element index to
+	// child tables generated from arrays, unique per parent row.
+	ArrayPositionColumn bool `json:"array_position_column,omitempty"`
+	// NullColumnType is the SQL type used for columns first seen with a null
+	// value. When empty such columns are deferred until a non-null value
+	// shows up.
+	NullColumnType string `json:"null_column_type,omitempty"`
+	// MixedArrayFallback selects how arrays mixing documents and scalars are
+	// stored: "jsonb" (default) or "child-values".
+	MixedArrayFallback string `json:"mixed_array_fallback,omitempty"`
+	// MetadataColumns stores the oplog ts and operation of the last change,
+	// the source namespace and the sync time on every replicated row.
+	MetadataColumns bool `json:"metadata_columns,omitempty"`
+	// TimestampGuard stores the oplog ts of the last change in _oplog_ts and
+	// makes updates and deletes no-ops for rows that are already newer.
+	TimestampGuard bool `json:"timestamp_guard,omitempty"`
+	// History records every operation in a <table>_history table, either
+	// alongside the current state tables ("both") or instead of them ("only").
+	History string `json:"history,omitempty"`
+	// Identifiers controls quoting and normalization of table and column names.
+	Identifiers IdentifierPolicy `json:"identifiers,omitempty"`
+}
+
+// NamespaceConfig holds the settings applied to a single "db.collection" namespace.
+type NamespaceConfig struct {
+	Fields FieldRules `json:"fields,omitempty"`
+	// SoftDelete turns deletes into an update setting _deleted,
+	// _deleted_at and _deleted_ts, the packed oplog ts of the delete,
+	// keeping the row. A later insert of the same _id clears the markers
+	// again.
+	SoftDelete bool `json:"soft_delete,omitempty"`
+}
+
+func LoadConfig(path string) (Config, error) {
+	var config Config
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return config, fmt.Errorf("reading parser config: %v", err)
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return config, fmt.Errorf("parsing parser config: %v", err)
+	}
+	return config, nil
+}
+
+func (c Config) Validate() error {
+	switch c.ChildIDStrategy {
+	case "", ChildIDRandom, ChildIDDeterministic:
+	default:
+		return fmt.Errorf("invalid child id strategy: %s", c.ChildIDStrategy)
+	}
+	if c.ChildIDStrategy == ChildIDRandom {
+		if c.Upsert {
+			return fmt.Errorf("upsert needs the deterministic child id strategy, random child ids duplicate child rows on replay")
+		}
+		for namespace, config := range c.Namespaces {
+			if config.SoftDelete {
+				return fmt.Errorf("soft delete of %s needs the deterministic child id strategy, random child ids duplicate child rows on re-insert", namespace)
+			}
+		}
+	}
+	switch c.MixedArrayFallback {
+	case "", MixedArrayJSONB, MixedArrayChildValues:
+	default:
+		return fmt.Errorf("invalid mixed array fallback: %s", c.MixedArrayFallback)
+	}
+	switch c.History {
+	case "", HistoryBoth, HistoryOnly:
+	default:
+		return fmt.Errorf("invalid history mode: %s", c.History)
+	}
+	switch c.Identifiers.Quote {
+	case "", QuoteAuto, QuoteAlways:
+	default:
+		return fmt.Errorf("invalid identifier quoting: %s", c.Identifiers.Quote)
+	}
+	return nil
+}
+
+// deterministicChildIDs reports whether child row ids are derived from the
+// parent _id and the array index, upsert telling whether the rows are
+// upserted.
+func (c Config) deterministicChildIDs(upsert bool) bool {
+	return c.ChildIDStrategy == ChildIDDeterministic || (c.ChildIDStrategy == "" && upsert)
+}
+
+func (c Config) namespace(namespace string) NamespaceConfig {
+	return c.Namespaces[namespace]
+}
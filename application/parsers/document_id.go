@@ -0,0 +1,152 @@
+package parsers
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// idValue marks a column holding a document _id, or a part of a composite
+// _id, so it is typed after the id value instead of the generic rules.
+type idValue struct {
+	value any
+}
+
+// normalizeID unwraps the extended JSON forms of an _id ({"$oid": ...},
+// {"$numberLong": ...}, {"$numberInt": ...}), also inside composite ids.
+func normalizeID(id any) any {
+	doc, ok := id.(map[string]any)
+	if !ok {
+		return id
+	}
+	if len(doc) == 1 {
+		if oid, ok := doc["$oid"].(string); ok {
+			return oid
+		}
+		for _, key := range []string{"$numberLong", "$numberInt"} {
+			if number, ok := doc[key].(string); ok {
+				if parsed, err := strconv.ParseInt(number, 10, 64); err == nil {
+					return parsed
+				}
+			}
+		}
+	}
+	normalized := make(map[string]any, len(doc))
+	for key, value := range doc {
+		normalized[key] = normalizeID(value)
+	}
+	return normalized
+}
+
+// idColumns maps an _id to the columns storing it. Scalar ids are stored in
+// column itself, composite ids get one column per field named column_field.
+func idColumns(column string, id any) (map[string]any, error) {
+	id = normalizeID(id)
+	doc, ok := id.(map[string]any)
+	if !ok {
+		if _, err := idSqlType(id); err != nil {
+			return nil, err
+		}
+		return map[string]any{column: idValue{value: id}}, nil
+	}
+	if len(doc) == 0 {
+		return nil, fmt.Errorf("empty composite _id")
+	}
+
+	columns := make(map[string]any, len(doc))
+	for field, value := range doc {
+		if _, err := idSqlType(value); err != nil {
+			return nil, fmt.Errorf("composite _id field %s: %v", field, err)
+		}
+		columns[fmt.Sprintf("%s_%s", column, field)] = idValue{value: value}
+	}
+	return columns, nil
+}
+
+// idKey returns a stable string form of an _id, used to derive child row ids.
+func idKey(id any) string {
+	id = normalizeID(id)
+	if str, ok := id.(string); ok {
+		return str
+	}
+	encoded, _ := json.Marshal(id)
+	return string(encoded)
+}
+
+// keyColumns returns the primary key columns of a row: _id, or the columns of
+// a composite _id when the row has no single _id column.
+func keyColumns(data map[string]any) []string {
+	if _, ok := data[fieldID]; ok {
+		return []string{fieldID}
+	}
+	var columns []string
+	for col, value := range data {
+		if _, ok := value.(idValue); ok {
+			columns = append(columns, col)
+		}
+	}
+	sort.Strings(columns)
+	return columns
+}
+
+// parentKeyColumns maps the _id of a row of table to the columns referencing
+// it from the rows of its child tables.
+func (op *opLogParser) parentKeyColumns(table string, id any) (map[string]any, error) {
+	columns, err := idColumns(fmt.Sprintf("%s_%s", table, fieldID), id)
+	if err != nil {
+		return nil, err
+	}
+	normalized := make(map[string]any, len(columns))
+	for column, value := range columns {
+		normalized[op.config.Identifiers.normalize(column)] = value
+	}
+	return normalized, nil
+}
+
+// idCondition renders the WHERE condition matching the row with the given _id.
+func (op *opLogParser) idCondition(namespace string, id any) (string, error) {
+	columns, err := idColumns(fieldID, id)
+	if err != nil {
+		return "", err
+	}
+	if columns, err = op.columnNames(namespace, columns); err != nil {
+		return "", err
+	}
+	return op.keyCondition(columns)
+}
+
+// keyCondition renders the WHERE condition matching the rows whose key
+// columns hold the given values.
+func (op *opLogParser) keyCondition(columns map[string]any) (string, error) {
+	var conditions []string
+	for col, value := range columns {
+		literal, err := formatValue(value)
+		if err != nil {
+			return "", fmt.Errorf("field %s: %v", col, err)
+		}
+		conditions = append(conditions, fmt.Sprintf("%s = %s", op.ident(col), literal))
+	}
+	sort.Strings(conditions)
+	return strings.Join(conditions, " AND "), nil
+}
+
+func idSqlType(value any) (string, error) {
+	switch val := value.(type) {
+	case string:
+		return "VARCHAR(255)", nil
+	case float64:
+		if val == math.Trunc(val) && math.Abs(val) < math.MaxInt64 {
+			return "BIGINT", nil
+		}
+		return "FLOAT", nil
+	case int, int8, int16, int32, int64, uint8, uint16, uint32:
+		return "BIGINT", nil
+	case bool:
+		return "BOOLEAN", nil
+	default:
+		return "", fmt.Errorf("unsupported _id type: %T", value)
+	}
+}
@@ -0,0 +1,144 @@
+package parsers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+const maskedValue = "****"
+
+// FieldRules projects and anonymises document fields before they are turned
+// into SQL. Fields are addressed by their dotted path from the document root,
+// e.g. "phone.personal"; elements of arrays share the path of the array.
+type FieldRules struct {
+	Include  []string       `json:"include,omitempty"`
+	Exclude  []string       `json:"exclude,omitempty"`
+	Mask     []string       `json:"mask,omitempty"`
+	Hash     []string       `json:"hash,omitempty"`
+	Truncate map[string]int `json:"truncate,omitempty"`
+}
+
+func (r FieldRules) isEmpty() bool {
+	return len(r.Include) == 0 && len(r.Exclude) == 0 && len(r.Mask) == 0 &&
+		len(r.Hash) == 0 && len(r.Truncate) == 0
+}
+
+// apply returns a copy of data with the rules applied. The top level _id is
+// always kept untouched so rows remain addressable.
+func (r FieldRules) apply(data map[string]any, salt string) map[string]any {
+	if r.isEmpty() {
+		return data
+	}
+	return r.applyObject(data, "", len(r.Include) == 0, salt)
+}
+
+func (r FieldRules) applyObject(data map[string]any, prefix string, included bool, salt string) map[string]any {
+	result := make(map[string]any, len(data))
+	for key, value := range data {
+		if prefix == "" && key == fieldID {
+			result[key] = value
+			continue
+		}
+
+		path := key
+		if prefix != "" {
+			path = prefix + "." + key
+		}
+		if slices.Contains(r.Exclude, path) {
+			continue
+		}
+
+		keep := included || slices.Contains(r.Include, path)
+		if !keep && !r.includesDescendant(path) {
+			continue
+		}
+
+		switch {
+		case slices.Contains(r.Hash, path):
+			value = hashValue(value, salt)
+		case slices.Contains(r.Mask, path):
+			if value != nil {
+				value = maskedValue
+			}
+		default:
+			nested, ok := r.applyNested(value, path, keep, salt)
+			if !ok {
+				continue
+			}
+			value = nested
+		}
+
+		if limit, ok := r.Truncate[path]; ok {
+			value = truncateValue(value, limit)
+		}
+		result[key] = value
+	}
+	return result
+}
+
+// applyNested descends into embedded documents and arrays of documents. It
+// reports false when a scalar is only reachable through a more specific
+// include path and therefore has to be dropped.
+func (r FieldRules) applyNested(value any, path string, included bool, salt string) (any, bool) {
+	switch val := value.(type) {
+	case map[string]any:
+		return r.applyObject(val, path, included, salt), true
+	case []any:
+		items := make([]any, 0, len(val))
+		for _, item := range val {
+			if obj, ok := item.(map[string]any); ok {
+				items = append(items, r.applyObject(obj, path, included, salt))
+			} else if included {
+				items = append(items, item)
+			}
+		}
+		return items, true
+	default:
+		return value, included
+	}
+}
+
+func (r FieldRules) includesDescendant(path string) bool {
+	for _, include := range r.Include {
+		if strings.HasPrefix(include, path+".") {
+			return true
+		}
+	}
+	return false
+}
+
+func hashValue(value any, salt string) any {
+	if value == nil {
+		return nil
+	}
+
+	var raw string
+	switch val := value.(type) {
+	case string:
+		raw = val
+	case map[string]any, []any:
+		encoded, _ := json.Marshal(val)
+		raw = string(encoded)
+	default:
+		raw = fmt.Sprintf("%v", val)
+	}
+
+	sum := sha256.Sum256([]byte(salt + raw))
+	return hex.EncodeToString(sum[:])
+}
+
+func truncateValue(value any, limit int) any {
+	str, ok := value.(string)
+	if !ok || limit < 0 {
+		return value
+	}
+	runes := []rune(str)
+	if len(runes) <= limit {
+		return value
+	}
+	return string(runes[:limit])
+}
@@ -0,0 +1,187 @@
+package parsers
+
+import (
+	"fmt"
+	"op-log-parser/application/domain/models"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	historySuffix = "_history"
+
+	fieldHistoryID = "_history_id"
+	fieldOperation = "_op"
+	fieldOplogTS   = "_oplog_ts"
+	fieldWall      = "_wall"
+	fieldTxnNumber = "_txn_number"
+	fieldChanges   = "_changes"
+)
+
+var operationNames = map[string]string{
+	Insert: "insert",
+	Update: "update",
+	Delete: "delete",
+}
+
+// historyColumns are the columns of a history table besides the _id columns.
+var historyColumns = map[string]string{
+	fieldOperation: "VARCHAR(10)",
+	fieldOplogTS:   "BIGINT",
+	fieldWall:      "TIMESTAMPTZ",
+	fieldTxnNumber: "BIGINT",
+	fieldChanges:   "JSONB",
+}
+
+// handleHistory appends the operation to the <table>_history table. Inserts
+// record the whole document, updates the set and unset fields and deletes
+// the deleted document when its pre-image is known.
+func (op *opLogParser) handleHistory(opLog models.OpLog) ([]string, error) {
+	id, changes, err := op.historyChanges(opLog)
+	if err != nil {
+		return nil, err
+	}
+
+	schema, table, err := op.sqlNamespace(opLog.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	table = op.config.Identifiers.normalize(table + historySuffix)
+	tableKey := fmt.Sprintf("%s.%s", schema, table)
+
+	row, err := idColumns(fieldID, id)
+	if err != nil {
+		return nil, err
+	}
+	if row, err = op.columnNames(tableKey, row); err != nil {
+		return nil, err
+	}
+	row[fieldOperation] = operationNames[opLog.Operation]
+	row[fieldOplogTS] = nil
+	if !opLog.Timestamp.IsZero() {
+		row[fieldOplogTS] = opLog.Timestamp.Int64()
+	}
+	row[fieldWall] = nil
+	if !opLog.Wall.IsZero() {
+		row[fieldWall] = opLog.Wall.Format(time.RFC3339Nano)
+	}
+	row[fieldTxnNumber] = nil
+	if opLog.TxnNumber != nil {
+		row[fieldTxnNumber] = *opLog.TxnNumber
+	}
+	row[fieldChanges] = nil
+	if changes != nil {
+		row[fieldChanges] = jsonValue{value: changes}
+	}
+
+	statements, err := op.schemaStatements(schema)
+	if err != nil {
+		return nil, err
+	}
+	if !op.tracker.IsDDLGenerated(tableKey) {
+		tableStatement, columnTypes, err := op.prepareHistoryTableDDL(schema, table, row)
+		if err != nil {
+			return nil, err
+		}
+		statements = append(statements, tableStatement)
+		if err := op.tracker.MarkDDLGenerated(tableKey); err != nil {
+			return nil, err
+		}
+		if err := op.tracker.InitializeColumnTracker(tableKey, columnTypes); err != nil {
+			return nil, err
+		}
+	} else {
+		alterStatements, err := op.addNewColumns(tableKey, schema, table, op.typedColumns(row))
+		if err != nil {
+			return nil, err
+		}
+		statements = append(statements, alterStatements...)
+	}
+
+	knownColumns := op.tracker.GetKnownColumns(tableKey)
+	delete(knownColumns, fieldHistoryID)
+	insertStatement, err := op.prepareInsertStatement(schema, table, row, knownColumns, nil, "")
+	if err != nil {
+		return nil, err
+	}
+	return append(statements, insertStatement), nil
+}
+
+// historyChanges returns the _id of the changed document and the changes
+// recorded for it, with the field rules of the namespace applied.
+func (op *opLogParser) historyChanges(opLog models.OpLog) (any, any, error) {
+	switch opLog.Operation {
+	case Insert:
+		data := op.applyFieldRules(opLog.Namespace, opLog.Data)
+		id, ok := data[fieldID]
+		if !ok {
+			return nil, nil, fmt.Errorf("_id field is missing")
+		}
+		return id, data, nil
+	case Update:
+		if opLog.O2 == nil || opLog.O2.ID == nil || opLog.O2.ID == "" {
+			return nil, nil, fmt.Errorf("_id field is missing")
+		}
+		diff, ok := opLog.Data[fieldDiff].(map[string]any)
+		if !ok {
+			return nil, nil, fmt.Errorf("invalid diff field in update oplog")
+		}
+		changes := make(map[string]any)
+		if setFields, ok := diff[fieldSet].(map[string]any); ok {
+			changes["set"] = op.applyFieldRules(opLog.Namespace, setFields)
+		}
+		if unsetFields, ok := diff[fieldUnset].(map[string]any); ok {
+			var unset []string
+			for field := range op.applyFieldRules(opLog.Namespace, unsetFields) {
+				unset = append(unset, field)
+			}
+			sort.Strings(unset)
+			changes["unset"] = unset
+		}
+		return opLog.O2.ID, changes, nil
+	default:
+		id, ok := opLog.Data[fieldID]
+		if !ok {
+			return nil, nil, fmt.Errorf("_id field is missing")
+		}
+		if opLog.PreImage != nil {
+			return id, op.applyFieldRules(opLog.Namespace, opLog.PreImage), nil
+		}
+		return id, nil, nil
+	}
+}
+
+// prepareHistoryTableDDL returns the history table DDL and its column types.
+// The _id columns are plain columns there, one document has many rows.
+func (op *opLogParser) prepareHistoryTableDDL(schema, table string, row map[string]any) (string, map[string]string, error) {
+	columnTypes := make(map[string]string, len(row)+1)
+	for col, value := range row {
+		if sqlType, ok := historyColumns[col]; ok {
+			columnTypes[col] = sqlType
+			continue
+		}
+		id, ok := value.(idValue)
+		if !ok {
+			return "", nil, fmt.Errorf("unexpected history column %s", col)
+		}
+		sqlType, err := idSqlType(id.value)
+		if err != nil {
+			return "", nil, err
+		}
+		columnTypes[col] = sqlType
+	}
+
+	var columns []string
+	for col := range columnTypes {
+		columns = append(columns, col)
+	}
+	sort.Strings(columns)
+
+	tableFields := []string{fmt.Sprintf("%s BIGSERIAL%s", op.ident(fieldHistoryID), primaryKey)}
+	for _, col := range columns {
+		tableFields = append(tableFields, fmt.Sprintf("%s %s", op.ident(col), columnTypes[col]))
+	}
+	columnTypes[fieldHistoryID] = "BIGSERIAL"
+	return fmt.Sprintf("%s %s (%s);", op.createClause("TABLE"), op.qualifiedName(schema, table), strings.Join(tableFields, ", ")), columnTypes, nil
+}
@@ -0,0 +1,212 @@
+package parsers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	QuoteAuto   = "auto"
+	QuoteAlways = "always"
+
+	// maxIdentifierLength is the PostgreSQL limit (NAMEDATALEN - 1) in bytes.
+	maxIdentifierLength = 63
+)
+
+var simpleIdentifier = regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
+
+// internalColumns are the columns the parser adds to rows itself. Document
+// fields never resolve to them.
+var internalColumns = []string{
+	fieldDeleted, fieldDeletedAt, fieldDeletedTS,
+	fieldOplogTS, fieldOplogOp, fieldSourceNS, fieldSyncedAt,
+	fieldPosition,
+}
+
+// reservedWords are the PostgreSQL keywords that cannot be used as bare
+// column or table names.
+var reservedWords = map[string]bool{
+	"all": true, "analyse": true, "analyze": true, "and": true, "any": true, "array": true,
+	"as": true, "asc": true, "asymmetric": true, "authorization": true, "binary": true,
+	"both": true, "case": true, "cast": true, "check": true, "collate": true, "collation": true,
+	"column": true, "concurrently": true, "constraint": true, "create": true, "cross": true,
+	"current_catalog": true, "current_date": true, "current_role": true, "current_schema": true,
+	"current_time": true, "current_timestamp": true, "current_user": true, "default": true,
+	"deferrable": true, "desc": true, "distinct": true, "do": true, "else": true, "end": true,
+	"except": true, "false": true, "fetch": true, "for": true, "foreign": true, "freeze": true,
+	"from": true, "full": true, "grant": true, "group": true, "having": true, "ilike": true,
+	"in": true, "initially": true, "inner": true, "intersect": true, "into": true, "is": true,
+	"isnull": true, "join": true, "lateral": true, "leading": true, "left": true, "like": true,
+	"limit": true, "localtime": true, "localtimestamp": true, "natural": true, "not": true,
+	"notnull": true, "null": true, "offset": true, "on": true, "only": true, "or": true,
+	"order": true, "outer": true, "overlaps": true, "placing": true, "primary": true,
+	"references": true, "returning": true, "right": true, "select": true, "session_user": true,
+	"similar": true, "some": true, "symmetric": true, "system_user": true, "table": true,
+	"tablesample": true, "then": true, "to": true, "trailing": true, "true": true, "union": true,
+	"unique": true, "user": true, "using": true, "variadic": true, "verbose": true, "when": true,
+	"where": true, "window": true, "with": true,
+}
+
+// IdentifierPolicy controls how Mongo names become SQL identifiers. Names are
+// quoted when they are not plain lowercase identifiers or are reserved words
+// ("auto"), or always ("always"). Names longer than PostgreSQL allows are
+// truncated with a hash suffix.
+type IdentifierPolicy struct {
+	Quote     string `json:"quote,omitempty"`
+	SnakeCase bool   `json:"snake_case,omitempty"`
+}
+
+func (p IdentifierPolicy) normalize(name string) string {
+	if p.SnakeCase {
+		name = toSnakeCase(name)
+	}
+	return truncateIdentifier(name, maxIdentifierLength)
+}
+
+func (p IdentifierPolicy) quote(name string) string {
+	if p.Quote != QuoteAlways && simpleIdentifier.MatchString(name) && !reservedWords[name] {
+		return name
+	}
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+func toSnakeCase(name string) string {
+	var b strings.Builder
+	runes := []rune(name)
+	for i, r := range runes {
+		switch {
+		case r == ' ' || r == '-':
+			b.WriteRune('_')
+		case unicode.IsUpper(r):
+			if i > 0 {
+				prev := runes[i-1]
+				nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+				if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextLower) {
+					b.WriteRune('_')
+				}
+			}
+			b.WriteRune(unicode.ToLower(r))
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+func truncateIdentifier(name string, limit int) string {
+	if len(name) <= limit {
+		return name
+	}
+	sum := sha256.Sum256([]byte(name))
+	suffix := "_" + hex.EncodeToString(sum[:4])
+	cut := limit - len(suffix)
+	for cut > 0 && !utf8.RuneStart(name[cut]) {
+		cut--
+	}
+	return name[:cut] + suffix
+}
+
+func (op *opLogParser) ident(name string) string {
+	return op.config.Identifiers.quote(name)
+}
+
+func (op *opLogParser) identList(names []string) string {
+	quoted := make([]string, len(names))
+	for i, name := range names {
+		quoted[i] = op.ident(name)
+	}
+	return strings.Join(quoted, ", ")
+}
+
+func (op *opLogParser) qualifiedName(schema, table string) string {
+	return op.ident(schema) + "." + op.ident(table)
+}
+
+// sqlNamespace splits a "db.collection" namespace into its normalized schema
+// and table names.
+func (op *opLogParser) sqlNamespace(namespace string) (schema, table string, err error) {
+	schema, table, err = parseNamespace(namespace)
+	if err != nil {
+		return "", "", err
+	}
+	return op.config.Identifiers.normalize(schema), op.config.Identifiers.normalize(table), nil
+}
+
+// columnNames returns data keyed by SQL column names instead of document
+// field names, which never resolve to the reserved columns.
+func (op *opLogParser) columnNames(namespace string, data map[string]any, reserved ...string) (map[string]any, error) {
+	fields := make([]string, 0, len(data))
+	for field := range data {
+		fields = append(fields, field)
+	}
+	names, err := op.resolveColumnNames(namespace, fields, reserved...)
+	if err != nil {
+		return nil, err
+	}
+
+	renamed := make(map[string]any, len(data))
+	for field, value := range data {
+		renamed[names[field]] = value
+	}
+	return renamed, nil
+}
+
+// isColumnName reports whether field is used as a column name unchanged.
+func (op *opLogParser) isColumnName(field string) bool {
+	return op.config.Identifiers.normalize(field) == field
+}
+
+// resolveColumnNames maps document field names to SQL column names. Mappings
+// are stored in the tracker, so a field always resolves to the same column
+// and two fields normalizing to the same name get distinct columns. The
+// internal columns and the reserved ones, such as the parent key of a child
+// table, are claimed before any field.
+func (op *opLogParser) resolveColumnNames(namespace string, fields []string, reserved ...string) (map[string]string, error) {
+	known := op.tracker.GetColumnNames(namespace)
+	claimed := make(map[string]bool, len(known)+len(internalColumns)+len(reserved))
+	for _, sqlName := range known {
+		claimed[sqlName] = true
+	}
+	for _, sqlName := range append(slices.Clip(internalColumns), reserved...) {
+		claimed[sqlName] = true
+	}
+
+	// Fields already spelled as their column name claim it first, so a
+	// colliding field that needs normalizing gets the suffix.
+	sort.Strings(fields)
+	sort.SliceStable(fields, func(i, j int) bool {
+		return op.isColumnName(fields[i]) && !op.isColumnName(fields[j])
+	})
+	names := make(map[string]string, len(fields))
+	newNames := make(map[string]string)
+	for _, field := range fields {
+		if sqlName, ok := known[field]; ok {
+			names[field] = sqlName
+			continue
+		}
+
+		base := op.config.Identifiers.normalize(field)
+		sqlName := base
+		for i := 2; claimed[sqlName]; i++ {
+			suffix := fmt.Sprintf("_%d", i)
+			sqlName = truncateIdentifier(base, maxIdentifierLength-len(suffix)) + suffix
+		}
+		claimed[sqlName] = true
+		names[field] = sqlName
+		newNames[field] = sqlName
+	}
+
+	if len(newNames) > 0 {
+		if err := op.tracker.SetColumnNames(namespace, newNames); err != nil {
+			return nil, err
+		}
+	}
+	return names, nil
+}
@@ -0,0 +1,121 @@
+package parsers
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// sqlExpr is a value rendered verbatim as a SQL expression, together with the
+// type of the column holding it.
+type sqlExpr struct {
+	expr    string
+	sqlType string
+}
+
+// formatValue renders v as a SQL literal. Composite values have no literal
+// form and must be split into child tables or wrapped as JSONB beforehand.
+func formatValue(v any) (string, error) {
+	switch val := v.(type) {
+	case nil:
+		return fieldNull, nil
+	case string:
+		return quoteString(val), nil
+	case bool:
+		return strconv.FormatBool(val), nil
+	case int:
+		return strconv.Itoa(val), nil
+	case int8, int16, int32, int64:
+		return fmt.Sprintf("%d", val), nil
+	case uint, uint8, uint16, uint32, uint64:
+		return fmt.Sprintf("%d", val), nil
+	case float32:
+		return formatFloat(float64(val), 32), nil
+	case float64:
+		return formatFloat(val, 64), nil
+	case []byte:
+		return fmt.Sprintf(`E'\\x%s'::bytea`, hex.EncodeToString(val)), nil
+	case jsonValue:
+		encoded, err := json.Marshal(val.value)
+		if err != nil {
+			return "", fmt.Errorf("encoding JSONB value: %v", err)
+		}
+		return quoteString(string(encoded)) + "::jsonb", nil
+	case idValue:
+		return formatValue(val.value)
+	case sqlExpr:
+		return val.expr, nil
+	case map[string]any, []any:
+		return "", fmt.Errorf("cannot render composite value as SQL literal: %T", v)
+	default:
+		return "", fmt.Errorf("unsupported value type for SQL literal: %T", v)
+	}
+}
+
+// binaryValue decodes the forms a BSON Binary value reaches the parser in:
+// {"Subtype": ..., "Data": ...} as the Go driver encodes it to JSON, and
+// the extended JSON {"$binary": {"base64": ..., "subType": ...}} and its
+// legacy form {"$binary": ..., "$type": ...}.
+func binaryValue(v any) ([]byte, bool) {
+	doc, ok := v.(map[string]any)
+	if !ok {
+		return nil, false
+	}
+	var encoded any
+	switch {
+	case len(doc) == 2 && doc["Data"] != nil:
+		if _, ok := doc["Subtype"].(float64); !ok {
+			return nil, false
+		}
+		encoded = doc["Data"]
+	case len(doc) == 2 && doc["$type"] != nil:
+		encoded = doc["$binary"]
+	case len(doc) == 1:
+		binary, ok := doc["$binary"].(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		encoded = binary["base64"]
+	default:
+		return nil, false
+	}
+	str, ok := encoded.(string)
+	if !ok {
+		return nil, false
+	}
+	data, err := base64.StdEncoding.DecodeString(str)
+	if err != nil {
+		return nil, false
+	}
+	return data, true
+}
+
+// quoteString doubles single quotes. Strings containing backslashes use the
+// escape string syntax so they are read the same whatever
+// standard_conforming_strings is set to.
+func quoteString(s string) string {
+	if strings.Contains(s, `\`) {
+		s = strings.ReplaceAll(s, `\`, `\\`)
+		return "E'" + strings.ReplaceAll(s, "'", "''") + "'"
+	}
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// formatFloat keeps the shortest representation that round trips, without
+// an exponent, and spells out the special values PostgreSQL accepts.
+func formatFloat(f float64, bitSize int) string {
+	switch {
+	case math.IsNaN(f):
+		return "'NaN'::float8"
+	case math.IsInf(f, 1):
+		return "'Infinity'::float8"
+	case math.IsInf(f, -1):
+		return "'-Infinity'::float8"
+	default:
+		return strconv.FormatFloat(f, 'f', -1, bitSize)
+	}
+}
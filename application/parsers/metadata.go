@@ -0,0 +1,92 @@
+package parsers
+
+import (
+	"fmt"
+	"op-log-parser/application/domain/models"
+	"strconv"
+)
+
+const (
+	fieldOplogOp  = "_oplog_op"
+	fieldSourceNS = "_source_ns"
+	fieldSyncedAt = "_synced_at"
+)
+
+// metadataColumns returns the replication metadata stored on every row of a
+// replicated table when MetadataColumns is enabled: the oplog ts and
+// operation of the last change, the source namespace and the sync time.
+// An entry without ts leaves the ts of the row unchanged, so it does not
+// reset the guard of later entries.
+func metadataColumns(opLog models.OpLog) map[string]any {
+	columns := map[string]any{
+		fieldOplogOp:  sqlExpr{expr: quoteString(operationNames[opLog.Operation]), sqlType: "VARCHAR(10)"},
+		fieldSourceNS: sqlExpr{expr: quoteString(opLog.Namespace), sqlType: "VARCHAR(255)"},
+		fieldSyncedAt: sqlExpr{expr: "now()", sqlType: "TIMESTAMPTZ"},
+	}
+	if !opLog.Timestamp.IsZero() {
+		columns[fieldOplogTS] = oplogTSColumn(opLog)
+	}
+	return columns
+}
+
+// oplogTSColumn returns the packed ts of opLog as the _oplog_ts value.
+func oplogTSColumn(opLog models.OpLog) sqlExpr {
+	return sqlExpr{expr: strconv.FormatInt(opLog.Timestamp.Int64(), 10), sqlType: "BIGINT"}
+}
+
+// metadata returns the metadata columns written with every change: all of
+// them with MetadataColumns, only _oplog_ts with TimestampGuard.
+func (op *opLogParser) metadata(opLog models.OpLog) map[string]any {
+	switch {
+	case op.config.MetadataColumns:
+		return metadataColumns(opLog)
+	case op.config.TimestampGuard && !opLog.Timestamp.IsZero():
+		return map[string]any{fieldOplogTS: oplogTSColumn(opLog)}
+	default:
+		return nil
+	}
+}
+
+// guarded reports whether the statements for opLog are guarded by its ts.
+func (op *opLogParser) guarded(opLog models.OpLog) bool {
+	return op.config.TimestampGuard && !opLog.Timestamp.IsZero()
+}
+
+// guardCondition returns the WHERE condition that only matches rows last
+// changed by an older oplog entry. Rows without a ts always match.
+func (op *opLogParser) guardCondition(opLog models.OpLog) string {
+	column := op.ident(fieldOplogTS)
+	return fmt.Sprintf("(%s IS NULL OR %s < %d)", column, column, opLog.Timestamp.Int64())
+}
+
+// guardedStatement records statement as guarded by the oplog ts, along with
+// the query selecting its target row, so a writer can tell a stale entry
+// from a missing row when the statement changes nothing.
+func (op *opLogParser) guardedStatement(statement, schema, table, condition string) string {
+	op.guards[statement] = fmt.Sprintf("SELECT 1 FROM %s WHERE %s;", op.qualifiedName(schema, table), condition)
+	return statement
+}
+
+// metadataSetClauses returns the SET clauses updating the metadata columns
+// of a row, preceded by the ALTER statement adding them to a tracked table
+// that does not have them yet.
+func (op *opLogParser) metadataSetClauses(opLog models.OpLog, namespace, schema, table string, tracked bool) ([]string, []string, error) {
+	metadata := op.metadata(opLog)
+	if metadata == nil {
+		return nil, nil, nil
+	}
+
+	var statements []string
+	if tracked {
+		alterStatements, err := op.addNewColumns(namespace, schema, table, metadata)
+		if err != nil {
+			return nil, nil, err
+		}
+		statements = alterStatements
+	}
+	sets, err := op.setClauses(metadata)
+	if err != nil {
+		return nil, nil, err
+	}
+	return statements, sets, nil
+}
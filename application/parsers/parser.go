@@ -3,8 +3,12 @@ package parsers
 import (
 	"encoding/json"
 	"fmt"
+	"maps"
 	"op-log-parser/application/domain/models"
+	"op-log-parser/application/domain/services"
+	"slices"
 	"sort"
+	"strconv"
 	"strings"
 )
 
@@ -18,6 +22,15 @@ const (
 	fieldSet   = "u"
 	fieldUnset = "d"
 	fieldNull  = "NULL"
+
+	fieldPosition  = "_position"
+	fieldDeleted   = "_deleted"
+	fieldDeletedAt = "_deleted_at"
+	fieldDeletedTS = "_deleted_ts"
+
+	primaryKey = " PRIMARY KEY"
+
+	noArrayIndex = -1
 )
 
 type OpLog struct {
@@ -28,30 +41,44 @@ type OpLog struct {
 }
 
 type O2Field struct {
-	ID string `bson:"_id" json:"_id"`
+	ID any `bson:"_id" json:"_id"`
 }
 
 type Parser interface {
 	Parse(oplogJson string) ([]string, error)
-	ProcessOpLog(opLog models.OpLog) ([]string, error)
+	ProcessOpLog(opLog models.OpLog) (statements, guards []string, err error)
+	StageSchema() func() error
+	DiscardSchema()
 }
 
 type opLogParser struct {
-	ddlTracker     map[string]bool
-	columnsTracker map[string]map[string]bool
-	uuidGenerator  UUIDGenerator
+	tracker           *stagedTracker
+	uuidGenerator     UUIDGenerator
+	config            Config
+	loggedArrayFields map[string]bool
+	// guards maps the statements of the current entry that are guarded by
+	// its oplog ts to the query selecting their target row.
+	guards map[string]string
 }
 
 type UUIDGenerator func() string
 
-func NewParser(uuidGenerator UUIDGenerator) Parser {
+// NewParser returns a parser recording the emitted DDL in tracker.
+func NewParser(uuidGenerator UUIDGenerator, tracker services.SchemaTracker) Parser {
+	return NewParserWithConfig(uuidGenerator, tracker, Config{})
+}
+
+func NewParserWithConfig(uuidGenerator UUIDGenerator, tracker services.SchemaTracker, config Config) Parser {
 	return &opLogParser{
-		ddlTracker:     make(map[string]bool),
-		columnsTracker: make(map[string]map[string]bool),
-		uuidGenerator:  uuidGenerator,
+		tracker:           newStagedTracker(tracker),
+		uuidGenerator:     uuidGenerator,
+		config:            config,
+		loggedArrayFields: make(map[string]bool),
 	}
 }
 
+// Parse returns the statements of every entry of opLogJson, recording their
+// schema changes in the tracker right away.
 func (op *opLogParser) Parse(opLogJson string) ([]string, error) {
 	var opLogs []models.OpLog
 	if err := json.Unmarshal([]byte(opLogJson), &opLogs); err != nil {
@@ -60,110 +87,311 @@ func (op *opLogParser) Parse(opLogJson string) ([]string, error) {
 	var statements []string
 
 	for _, opLog := range opLogs {
-		processedStatements, err := op.ProcessOpLog(opLog)
+		processedStatements, _, err := op.ProcessOpLog(opLog)
 		if err != nil {
 			return nil, err
 		}
+		if err := op.StageSchema()(); err != nil {
+			return nil, err
+		}
 		statements = append(statements, processedStatements...)
 	}
 	return statements, nil
 }
 
-func (op *opLogParser) ProcessOpLog(opLog models.OpLog) ([]string, error) {
+// ProcessOpLog returns the statements for one oplog entry. Its schema
+// changes are staged with StageSchema, or dropped when it fails along with
+// those of the entries processed since.
+// guards holds, per statement, the query selecting the target row of a
+// statement guarded by the oplog ts, or is empty when none is guarded.
+func (op *opLogParser) ProcessOpLog(opLog models.OpLog) (statements, guards []string, err error) {
+	op.guards = make(map[string]string)
+	statements, err = op.processOpLog(opLog)
+	if err != nil {
+		op.tracker.discard()
+		return nil, nil, err
+	}
+	if len(op.guards) > 0 {
+		guards = make([]string, len(statements))
+		for i, statement := range statements {
+			guards[i] = op.guards[statement]
+		}
+	}
+	return statements, guards, nil
+}
+
+// StageSchema ends the unit of the entries processed since the last call to
+// StageSchema or DiscardSchema. Later entries see its schema changes, the
+// tracker records them when the returned function is called.
+func (op *opLogParser) StageSchema() func() error {
+	return op.tracker.stage()
+}
+
+// DiscardSchema drops the schema changes of the entries processed since the
+// last call to StageSchema or DiscardSchema.
+func (op *opLogParser) DiscardSchema() {
+	op.tracker.discard()
+}
+
+func (op *opLogParser) processOpLog(opLog models.OpLog) ([]string, error) {
+	var handle func(models.OpLog) ([]string, error)
 	switch opLog.Operation {
 	case Insert:
-		return op.handleInsert(opLog)
+		handle = op.handleInsert
 	case Update:
-		return op.handleUpdate(opLog)
+		handle = op.handleUpdate
 	case Delete:
-		return op.handleDelete(opLog)
+		handle = op.handleDelete
 	default:
 		return nil, fmt.Errorf("unsupported oplog operation: %s", opLog.Operation)
 	}
+
+	var statements []string
+	if op.config.History != HistoryOnly {
+		current, err := handle(opLog)
+		if err != nil {
+			return nil, err
+		}
+		statements = append(statements, current...)
+	}
+	if op.config.History != "" {
+		history, err := op.handleHistory(opLog)
+		if err != nil {
+			return nil, err
+		}
+		statements = append(statements, history...)
+	}
+	return statements, nil
 }
 
 func (op *opLogParser) handleInsert(opLog models.OpLog) ([]string, error) {
-	schema, table, err := parseNamespace(opLog.Namespace)
+	schema, table, err := op.sqlNamespace(opLog.Namespace)
 	if err != nil {
 		return nil, err
 	}
+	tableKey := fmt.Sprintf("%s.%s", schema, table)
 
 	var statements []string
-	mainData, nestedData, arrayData := splitData(opLog.Data)
-
-	if !op.isDDLGenerated(opLog.Namespace) {
-		schemaStatement := fmt.Sprintf("CREATE SCHEMA %s;", schema)
-		tableStatement, err := prepareTableDDL(schema, table, mainData)
+	data := op.applyFieldRules(opLog.Namespace, opLog.Data)
+	mainData, nestedData, arrayData, err := op.splitData(opLog.Namespace, data)
+	if err != nil {
+		return nil, err
+	}
+	if id, ok := mainData[fieldID]; ok {
+		columns, err := idColumns(fieldID, id)
 		if err != nil {
 			return nil, err
 		}
-		statements = append(statements, schemaStatement, tableStatement)
+		delete(mainData, fieldID)
+		maps.Copy(mainData, columns)
+	}
+	mainData, err = op.columnNames(tableKey, mainData)
+	if err != nil {
+		return nil, err
+	}
+	if err := op.recordNullColumns(tableKey, mainData); err != nil {
+		return nil, err
+	}
+	softDelete := op.config.namespace(opLog.Namespace).SoftDelete
+	if softDelete {
+		maps.Copy(mainData, softDeleteColumns(false, fieldNull, fieldNull))
+	}
+	// A soft deleted row is still there when its document is inserted again
+	upsert := op.config.Upsert || softDelete
+	maps.Copy(mainData, op.metadata(opLog))
 
-		for field, nestedObj := range nestedData {
-			nestedTable := fmt.Sprintf("%s_%s", table, field)
-			nestedStatements, err := op.generateTableDDLAndInsertForNestedObject(schema, nestedTable, opLog.Data[fieldID].(string), table, nestedObj)
-			if err != nil {
-				return nil, err
-			}
-			statements = append(statements, nestedStatements...)
+	schemaStatements, err := op.schemaStatements(schema)
+	if err != nil {
+		return nil, err
+	}
+	statements = append(statements, schemaStatements...)
+
+	if !op.tracker.IsDDLGenerated(tableKey) {
+		tableStatement, err := op.prepareTableDDL(schema, table, op.typedColumns(mainData))
+		if err != nil {
+			return nil, err
 		}
+		statements = append(statements, tableStatement)
 
-		for field, nestedArray := range arrayData {
-			nestedTable := fmt.Sprintf("%s_%s", table, field)
-			nestedStatements, err := op.generateTableDDLAndInsertForArray(schema, nestedTable, opLog.Data[fieldID].(string), table, nestedArray)
-			if err != nil {
-				return nil, err
-			}
-			statements = append(statements, nestedStatements...)
+		childStatements, err := op.generateChildStatements(schema, table, data[fieldID], nestedData, arrayData, upsert)
+		if err != nil {
+			return nil, err
 		}
+		statements = append(statements, childStatements...)
 
-		op.markDDLGenerated(opLog.Namespace)
-		op.initializeColumnTracker(opLog.Namespace, mainData)
+		if err := op.tracker.MarkDDLGenerated(tableKey); err != nil {
+			return nil, err
+		}
+		columnTypes, err := op.getColumnTypes(op.typedColumns(mainData))
+		if err != nil {
+			return nil, err
+		}
+		if err := op.tracker.InitializeColumnTracker(tableKey, columnTypes); err != nil {
+			return nil, err
+		}
 	} else {
-		newFields := make(map[string]any)
-		knownColumns := op.getKnownColumns(opLog.Namespace)
-		for col, value := range mainData {
-			if !knownColumns[col] {
-				newFields[col] = value
-			}
+		alterStatements, err := op.addNewColumns(tableKey, schema, table, mainData)
+		if err != nil {
+			return nil, err
 		}
+		statements = append(statements, alterStatements...)
 
-		if len(newFields) > 0 {
-			alterStatement, err := prepareAlterStatement(schema, table, newFields)
-			if err != nil {
-				return nil, err
-			}
-			statements = append(statements, alterStatement)
-			op.updateColumnsTracker(opLog.Namespace, newFields)
+		childStatements, err := op.generateChildStatements(schema, table, data[fieldID], nestedData, arrayData, upsert)
+		if err != nil {
+			return nil, err
 		}
-		for field, nestedObj := range nestedData {
-			nestedTable := fmt.Sprintf("%s_%s", table, field)
-			nestedStatements, err := op.generateTableDDLAndInsertForNestedObject(schema, nestedTable, opLog.Data[fieldID].(string), table, nestedObj)
-			if err != nil {
-				return nil, err
-			}
-			statements = append(statements, nestedStatements...)
+		statements = append(statements, childStatements...)
+	}
+	if err := op.checkColumnTypes(tableKey, mainData); err != nil {
+		return nil, err
+	}
+	knownColumns := op.tracker.GetKnownColumns(tableKey)
+	var guard string
+	if upsert && op.guarded(opLog) {
+		column := fmt.Sprintf("%s.%s", op.qualifiedName(schema, table), op.ident(fieldOplogTS))
+		guard = fmt.Sprintf("%s IS NULL OR %s < EXCLUDED.%s", column, column, op.ident(fieldOplogTS))
+	}
+	var conflictKey []string
+	if upsert {
+		conflictKey = keyColumns(mainData)
+	}
+	insertStatement, err := op.prepareInsertStatement(schema, table, mainData, knownColumns, conflictKey, guard)
+	if err != nil {
+		return nil, err
+	}
+	if guard != "" {
+		condition, err := op.idCondition(tableKey, data[fieldID])
+		if err != nil {
+			return nil, err
 		}
-		for field, nestedArray := range arrayData {
-			nestedTable := fmt.Sprintf("%s_%s", table, field)
-			nestedStatements, err := op.generateTableDDLAndInsertForArray(schema, nestedTable, opLog.Data[fieldID].(string), table, nestedArray)
-			if err != nil {
-				return nil, err
-			}
-			statements = append(statements, nestedStatements...)
+		insertStatement = op.guardedStatement(insertStatement, schema, table, condition)
+	}
+	statements = append(statements, insertStatement)
+	return statements, nil
+}
+
+// schemaStatements returns the CREATE SCHEMA statement the first time schema
+// is seen.
+func (op *opLogParser) schemaStatements(schema string) ([]string, error) {
+	if op.tracker.IsDDLGenerated(schema) {
+		return nil, nil
+	}
+	if err := op.tracker.MarkDDLGenerated(schema); err != nil {
+		return nil, err
+	}
+	return []string{fmt.Sprintf("%s %s;", op.createClause("SCHEMA"), op.ident(schema))}, nil
+}
+
+// addNewColumns returns the ALTER statement for the columns of data that the
+// tracker does not know yet, if any, and records them.
+func (op *opLogParser) addNewColumns(namespace, schema, table string, data map[string]any) ([]string, error) {
+	newFields := make(map[string]any)
+	knownColumns := op.tracker.GetKnownColumns(namespace)
+	for col, value := range op.typedColumns(data) {
+		if !knownColumns[col] {
+			newFields[col] = value
 		}
 	}
-	knownColumns := op.getKnownColumns(opLog.Namespace)
-	insertStatement, err := prepareInsertStatement(schema, table, opLog.Data, knownColumns)
+	if len(newFields) == 0 {
+		return nil, nil
+	}
+
+	alterStatement, err := op.prepareAlterStatement(schema, table, newFields)
 	if err != nil {
 		return nil, err
 	}
-	statements = append(statements, insertStatement)
+	columnTypes, err := op.getColumnTypes(newFields)
+	if err != nil {
+		return nil, err
+	}
+	if err := op.tracker.UpdateColumnsTracker(namespace, columnTypes); err != nil {
+		return nil, err
+	}
+	return []string{alterStatement}, nil
+}
+
+// generateChildStatements returns the statements creating and filling the
+// child tables of nestedData and arrayData. upsert makes the child rows
+// replace existing ones, as for their parent row.
+func (op *opLogParser) generateChildStatements(schema, table string, id any, nestedData map[string]any, arrayData map[string][]any, upsert bool) ([]string, error) {
+	if len(nestedData) == 0 && len(arrayData) == 0 {
+		return nil, nil
+	}
+	if id == nil {
+		return nil, fmt.Errorf("_id field is missing for nested data")
+	}
+	parentID := idKey(id)
+	parentColumns, err := op.parentKeyColumns(table, id)
+	if err != nil {
+		return nil, err
+	}
+
+	var statements []string
+	for field, nestedObj := range nestedData {
+		nestedTable := op.config.Identifiers.normalize(fmt.Sprintf("%s_%s", table, field))
+		nestedStatements, err := op.generateTableDDLAndInsertForNestedObject(schema, nestedTable, parentID, parentColumns, noArrayIndex, nestedObj, upsert)
+		if err != nil {
+			return nil, err
+		}
+		statements = append(statements, nestedStatements...)
+	}
+
+	for field, nestedArray := range arrayData {
+		nestedTable := op.config.Identifiers.normalize(fmt.Sprintf("%s_%s", table, field))
+		nestedStatements, err := op.generateTableDDLAndInsertForArray(schema, nestedTable, parentID, parentColumns, nestedArray, upsert)
+		if err != nil {
+			return nil, err
+		}
+		statements = append(statements, nestedStatements...)
+	}
 	return statements, nil
 }
 
+// replaceChildStatements returns the statements replacing the child table
+// rows of the embedded documents and arrays set by an update of the document
+// with the given id: the rows of child tables already created are deleted,
+// then the new rows are inserted as for an insert.
+func (op *opLogParser) replaceChildStatements(schema, table string, id any, nestedData map[string]any, arrayData map[string][]any, upsert bool) ([]string, error) {
+	if len(nestedData) == 0 && len(arrayData) == 0 {
+		return nil, nil
+	}
+	parentColumns, err := op.parentKeyColumns(table, id)
+	if err != nil {
+		return nil, err
+	}
+	condition, err := op.keyCondition(parentColumns)
+	if err != nil {
+		return nil, err
+	}
+
+	var fields []string
+	for field := range nestedData {
+		fields = append(fields, field)
+	}
+	for field := range arrayData {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	var statements []string
+	for _, field := range fields {
+		childTable := op.config.Identifiers.normalize(fmt.Sprintf("%s_%s", table, field))
+		childKey := fmt.Sprintf("%s.%s", schema, childTable)
+		if !op.tracker.IsDDLGenerated(childKey) {
+			continue
+		}
+		statements = append(statements, fmt.Sprintf("DELETE FROM %s WHERE %s;", op.qualifiedName(schema, childTable), condition))
+	}
+
+	childStatements, err := op.generateChildStatements(schema, table, id, nestedData, arrayData, upsert)
+	if err != nil {
+		return nil, err
+	}
+	return append(statements, childStatements...), nil
+}
+
 func (op *opLogParser) handleUpdate(opLog models.OpLog) ([]string, error) {
-	if opLog.O2 == nil || opLog.O2.ID == "" {
+	if opLog.O2 == nil || opLog.O2.ID == nil || opLog.O2.ID == "" {
 		return nil, fmt.Errorf("_id field is missing")
 	}
 
@@ -172,16 +400,62 @@ func (op *opLogParser) handleUpdate(opLog models.OpLog) ([]string, error) {
 		return nil, fmt.Errorf("invalid diff field in update oplog")
 	}
 
-	schema, table, err := parseNamespace(opLog.Namespace)
+	schema, table, err := op.sqlNamespace(opLog.Namespace)
 	if err != nil {
 		return nil, err
 	}
+	tableKey := fmt.Sprintf("%s.%s", schema, table)
+
+	// Columns are only resolved against the tracker once the table is known,
+	// updates for tables created elsewhere are passed through as they are.
+	tracked := op.tracker.IsDDLGenerated(tableKey)
+	knownColumns := op.tracker.GetKnownColumns(tableKey)
 
+	var statements []string
 	var setClauses []string
 	if setFields, ok := diff[fieldSet].(map[string]any); ok {
+		setFields = op.applyFieldRules(opLog.Namespace, setFields)
+		mainData, nestedData, arrayData, err := op.splitData(opLog.Namespace, setFields)
+		if err != nil {
+			return nil, err
+		}
+		if mainData, err = op.columnNames(tableKey, mainData); err != nil {
+			return nil, err
+		}
+		if err := op.recordNullColumns(tableKey, mainData); err != nil {
+			return nil, err
+		}
+		if tracked {
+			alterStatements, err := op.addNewColumns(tableKey, schema, table, mainData)
+			if err != nil {
+				return nil, err
+			}
+			statements = append(statements, alterStatements...)
+			knownColumns = op.tracker.GetKnownColumns(tableKey)
+		}
+		if err := op.checkColumnTypes(tableKey, mainData); err != nil {
+			return nil, err
+		}
+
+		// Embedded documents and arrays of documents replace the rows of
+		// their child tables
+		childStatements, err := op.replaceChildStatements(schema, table, opLog.O2.ID, nestedData, arrayData,
+			op.config.Upsert || op.config.namespace(opLog.Namespace).SoftDelete)
+		if err != nil {
+			return nil, err
+		}
+		statements = append(statements, childStatements...)
+
 		var sets []string
-		for field, value := range setFields {
-			sets = append(sets, fmt.Sprintf("%s = %s", field, formatValue(value)))
+		for field, value := range mainData {
+			if tracked && value == nil && !knownColumns[field] {
+				continue
+			}
+			literal, err := formatValue(value)
+			if err != nil {
+				return nil, fmt.Errorf("field %s: %v", field, err)
+			}
+			sets = append(sets, fmt.Sprintf("%s = %s", op.ident(field), literal))
 		}
 		if len(sets) > 0 {
 			sort.Strings(sets)
@@ -190,9 +464,24 @@ func (op *opLogParser) handleUpdate(opLog models.OpLog) ([]string, error) {
 	}
 
 	if unsetFields, ok := diff[fieldUnset].(map[string]any); ok {
+		unsetFields, err = op.columnNames(tableKey, op.applyFieldRules(opLog.Namespace, unsetFields))
+		if err != nil {
+			return nil, err
+		}
 		var sets []string
+		var unset []string
 		for field := range unsetFields {
-			sets = append(sets, fmt.Sprintf("%s = %s", field, fieldNull))
+			unset = append(unset, field)
+			if tracked && !knownColumns[field] {
+				continue
+			}
+			sets = append(sets, fmt.Sprintf("%s = %s", op.ident(field), fieldNull))
+		}
+		if len(unset) > 0 {
+			sort.Strings(unset)
+			if err := op.tracker.MarkColumnsNullable(tableKey, unset); err != nil {
+				return nil, err
+			}
 		}
 		if len(sets) > 0 {
 			sort.Strings(sets)
@@ -200,8 +489,21 @@ func (op *opLogParser) handleUpdate(opLog models.OpLog) ([]string, error) {
 		}
 
 	}
-	return []string{fmt.Sprintf("UPDATE %s.%s SET %s WHERE _id = '%s';",
-		schema, table, strings.Join(setClauses, ", "), opLog.O2.ID)}, nil
+	if len(setClauses) == 0 {
+		return statements, nil
+	}
+	metadataStatements, metadataSets, err := op.metadataSetClauses(opLog, tableKey, schema, table, tracked)
+	if err != nil {
+		return nil, err
+	}
+	statements = append(statements, metadataStatements...)
+	setClauses = append(setClauses, metadataSets...)
+
+	condition, err := op.idCondition(tableKey, opLog.O2.ID)
+	if err != nil {
+		return nil, err
+	}
+	return append(statements, op.updateStatement(opLog, schema, table, setClauses, condition)), nil
 }
 
 func (op *opLogParser) handleDelete(opLog models.OpLog) ([]string, error) {
@@ -209,71 +511,156 @@ func (op *opLogParser) handleDelete(opLog models.OpLog) ([]string, error) {
 	if !ok {
 		return nil, fmt.Errorf("_id field is missing")
 	}
-	schema, table, err := parseNamespace(opLog.Namespace)
+	schema, table, err := op.sqlNamespace(opLog.Namespace)
 	if err != nil {
 		return nil, err
 	}
-	return []string{fmt.Sprintf("DELETE FROM %s.%s WHERE _id = %s;", schema, table, formatValue(id))}, nil
-}
+	tableKey := fmt.Sprintf("%s.%s", schema, table)
+	condition, err := op.idCondition(tableKey, id)
+	if err != nil {
+		return nil, err
+	}
+	if !op.config.namespace(opLog.Namespace).SoftDelete {
+		var statements []string
+		if op.guarded(opLog) {
+			if op.tracker.IsDDLGenerated(tableKey) {
+				alterStatements, err := op.addNewColumns(tableKey, schema, table, op.metadata(opLog))
+				if err != nil {
+					return nil, err
+				}
+				statements = append(statements, alterStatements...)
+			}
+			statement := fmt.Sprintf("DELETE FROM %s WHERE %s AND %s;",
+				op.qualifiedName(schema, table), condition, op.guardCondition(opLog))
+			return append(statements, op.guardedStatement(statement, schema, table, condition)), nil
+		}
+		return []string{fmt.Sprintf("DELETE FROM %s WHERE %s;", op.qualifiedName(schema, table), condition)}, nil
+	}
 
-func (op *opLogParser) getKnownColumns(namespace string) map[string]bool {
-	if columns, exists := op.columnsTracker[namespace]; exists {
-		return columns
+	// to_timestamp only has the seconds of the ts, deletes within the same
+	// second are ordered by the packed ts in _deleted_ts
+	deletedAt, deletedTS := "now()", fieldNull
+	if !opLog.Timestamp.IsZero() {
+		deletedAt = fmt.Sprintf("to_timestamp(%d)", opLog.Timestamp.T)
+		deletedTS = strconv.FormatInt(opLog.Timestamp.Int64(), 10)
 	}
-	return make(map[string]bool)
-}
+	markers := softDeleteColumns(true, deletedAt, deletedTS)
 
-func (op *opLogParser) isDDLGenerated(namespace string) bool {
-	return op.ddlTracker[namespace]
-}
+	var statements []string
+	tracked := op.tracker.IsDDLGenerated(tableKey)
+	if tracked {
+		alterStatements, err := op.addNewColumns(tableKey, schema, table, markers)
+		if err != nil {
+			return nil, err
+		}
+		statements = append(statements, alterStatements...)
+	}
+	setClauses := []string{
+		fmt.Sprintf("%s = %s", op.ident(fieldDeletedAt), deletedAt),
+		fmt.Sprintf("%s = %s", op.ident(fieldDeletedTS), deletedTS),
+		fmt.Sprintf("%s = true", op.ident(fieldDeleted)),
+	}
+	metadataStatements, metadataSets, err := op.metadataSetClauses(opLog, tableKey, schema, table, tracked)
+	if err != nil {
+		return nil, err
+	}
+	statements = append(statements, metadataStatements...)
+	setClauses = append(setClauses, metadataSets...)
 
-func (op *opLogParser) markDDLGenerated(namespace string) {
-	op.ddlTracker[namespace] = true
+	return append(statements, op.updateStatement(opLog, schema, table, setClauses, condition)), nil
 }
 
-func (op *opLogParser) initializeColumnTracker(namespace string, data map[string]any) {
-	op.columnsTracker[namespace] = make(map[string]bool)
-	for col, _ := range data {
-		op.columnsTracker[namespace][col] = true
+// updateStatement renders an UPDATE of the row matching condition, guarded
+// by the oplog ts when enabled.
+func (op *opLogParser) updateStatement(opLog models.OpLog, schema, table string, setClauses []string, condition string) string {
+	if op.guarded(opLog) {
+		statement := fmt.Sprintf("UPDATE %s SET %s WHERE %s AND %s;",
+			op.qualifiedName(schema, table), strings.Join(setClauses, ", "), condition, op.guardCondition(opLog))
+		return op.guardedStatement(statement, schema, table, condition)
 	}
+	return fmt.Sprintf("UPDATE %s SET %s WHERE %s;",
+		op.qualifiedName(schema, table), strings.Join(setClauses, ", "), condition)
 }
 
-func (op *opLogParser) updateColumnsTracker(namespace string, newFields map[string]any) {
-	if columns, exists := op.columnsTracker[namespace]; exists {
-		for field := range newFields {
-			columns[field] = true
+// setClauses renders "column = value" assignments for data, sorted.
+func (op *opLogParser) setClauses(data map[string]any) ([]string, error) {
+	var sets []string
+	for col, value := range data {
+		literal, err := formatValue(value)
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %v", col, err)
 		}
+		sets = append(sets, fmt.Sprintf("%s = %s", op.ident(col), literal))
 	}
+	sort.Strings(sets)
+	return sets, nil
+}
+
+// softDeleteColumns returns the soft delete marker columns with their values.
+func softDeleteColumns(deleted bool, deletedAt, deletedTS string) map[string]any {
+	return map[string]any{
+		fieldDeleted:   deleted,
+		fieldDeletedAt: sqlExpr{expr: deletedAt, sqlType: "TIMESTAMPTZ"},
+		fieldDeletedTS: sqlExpr{expr: deletedTS, sqlType: "BIGINT"},
+	}
+}
+
+func (op *opLogParser) applyFieldRules(namespace string, data map[string]any) map[string]any {
+	return op.config.namespace(namespace).Fields.apply(data, op.config.HashSalt)
 }
 
-func splitData(data map[string]any) (main, nested map[string]any, arrays map[string][]any) {
+func (op *opLogParser) splitData(namespace string, data map[string]any) (main, nested map[string]any, arrays map[string][]any, err error) {
 	main = make(map[string]any)
 	nested = make(map[string]any)
 	arrays = make(map[string][]any)
 
 	for key, value := range data {
+		if key == fieldID {
+			// Composite ids are mapped to key columns, not to a child table.
+			main[key] = value
+			continue
+		}
+		if data, ok := binaryValue(value); ok {
+			main[key] = data
+			continue
+		}
 		switch val := value.(type) {
 		case map[string]any:
 			nested[key] = val
 		case []any:
-			if len(val) > 0 {
-				if _, ok := val[0].(map[string]any); ok {
-					arrays[key] = val
+			switch kind := classifyArray(val); kind {
+			case arrayEmpty:
+				op.logArrayDecision(namespace, key, kind, "leaving the target unchanged")
+			case arrayObjects:
+				op.logArrayDecision(namespace, key, kind, "storing as child table")
+				arrays[key] = val
+			case arrayScalars:
+				op.logArrayDecision(namespace, key, kind, "storing as JSONB column")
+				main[key] = jsonValue{value: val}
+			case arrayMixed:
+				if op.config.MixedArrayFallback == MixedArrayChildValues {
+					op.logArrayDecision(namespace, key, kind, "storing as child value table")
+					rows, err := childValues(val)
+					if err != nil {
+						return nil, nil, nil, err
+					}
+					arrays[key] = rows
 				} else {
-					main[key] = val
+					op.logArrayDecision(namespace, key, kind, "storing as JSONB column")
+					main[key] = jsonValue{value: val}
 				}
 			}
 		default:
 			main[key] = val
 		}
 	}
-	return main, nested, arrays
+	return main, nested, arrays, nil
 }
 
-func (op *opLogParser) generateTableDDLAndInsertForArray(schema, table, parentID, parentTable string, arrayData []any) ([]string, error) {
+func (op *opLogParser) generateTableDDLAndInsertForArray(schema, table, parentID string, parentColumns map[string]any, arrayData []any, upsert bool) ([]string, error) {
 	var statements []string
-	for _, item := range arrayData {
-		statement, err := op.generateTableDDLAndInsertForNestedObject(schema, table, parentID, parentTable, item)
+	for index, item := range arrayData {
+		statement, err := op.generateTableDDLAndInsertForNestedObject(schema, table, parentID, parentColumns, index, item, upsert)
 		if err != nil {
 			return nil, err
 		}
@@ -282,7 +669,7 @@ func (op *opLogParser) generateTableDDLAndInsertForArray(schema, table, parentID
 	return statements, nil
 }
 
-func (op *opLogParser) generateTableDDLAndInsertForNestedObject(schema, table, parentID, parentTable string, data any) ([]string, error) {
+func (op *opLogParser) generateTableDDLAndInsertForNestedObject(schema, table, parentID string, parentColumns map[string]any, index int, data any, upsert bool) ([]string, error) {
 	var statements []string
 
 	nestedData, ok := data.(map[string]any)
@@ -290,23 +677,73 @@ func (op *opLogParser) generateTableDDLAndInsertForNestedObject(schema, table, p
 		return nil, fmt.Errorf("expected map[string]any for %s, got %T", table, data)
 	}
 
-	nestedData[fieldID] = op.uuidGenerator()
-	nestedData[fmt.Sprintf("%s_%s", parentTable, fieldID)] = parentID
 	tableSchemaName := fmt.Sprintf("%s.%s", schema, table)
+	for field, value := range nestedData {
+		if data, ok := binaryValue(value); ok {
+			nestedData[field] = data
+		}
+	}
+	nestedData[fieldID] = op.childID(tableSchemaName, parentID, index, upsert)
+
+	// Fields named like the parent key get other columns
+	parentKey := slices.Sorted(maps.Keys(parentColumns))
+	rowData, err := op.columnNames(tableSchemaName, nestedData, parentKey...)
+	if err != nil {
+		return nil, err
+	}
+	maps.Copy(rowData, parentColumns)
+	if op.config.ArrayPositionColumn && index != noArrayIndex {
+		rowData[fieldPosition] = index
+	}
+	if err := op.recordNullColumns(tableSchemaName, rowData); err != nil {
+		return nil, err
+	}
 
-	if !op.isDDLGenerated(tableSchemaName) {
-		tableStatement, err := prepareNestedTableDDL(schema, table, nestedData, parentTable, parentID)
+	if !op.tracker.IsDDLGenerated(tableSchemaName) {
+		tableStatement, err := op.prepareNestedTableDDL(schema, table, op.typedColumns(rowData), parentKey)
 		if err != nil {
 			return nil, err
 		}
 
-		op.markDDLGenerated(tableSchemaName)
-		op.initializeColumnTracker(tableSchemaName, nestedData)
+		if err := op.tracker.MarkDDLGenerated(tableSchemaName); err != nil {
+			return nil, err
+		}
+		columnTypes, err := op.getColumnTypes(op.typedColumns(rowData))
+		if err != nil {
+			return nil, err
+		}
+		if err := op.tracker.InitializeColumnTracker(tableSchemaName, columnTypes); err != nil {
+			return nil, err
+		}
 		statements = append(statements, tableStatement)
+	} else {
+		// Fields first seen in a later document become new columns of the
+		// existing child table
+		_, hadPosition := op.tracker.GetKnownColumns(tableSchemaName)[fieldPosition]
+		alterStatements, err := op.addNewColumns(tableSchemaName, schema, table, rowData)
+		if err != nil {
+			return nil, err
+		}
+		statements = append(statements, alterStatements...)
+		if _, ok := rowData[fieldPosition]; ok && !hadPosition {
+			statements = append(statements, op.positionIndexStatement(schema, table, parentKey))
+		}
 	}
 
-	knownColumns := op.getKnownColumns(tableSchemaName)
-	insertStmt, err := prepareInsertStatement(schema, table, nestedData, knownColumns)
+	if err := op.checkColumnTypes(tableSchemaName, rowData); err != nil {
+		return nil, err
+	}
+	knownColumns := op.tracker.GetKnownColumns(tableSchemaName)
+	var conflictKey []string
+	if upsert {
+		// Child ids may be random, so a replayed array element is matched by
+		// its parent and position instead
+		conflictKey = keyColumns(rowData)
+		if _, ok := rowData[fieldPosition]; ok {
+			conflictKey = append(slices.Clone(parentKey), fieldPosition)
+		}
+	}
+	insertStmt, err := op.prepareInsertStatement(schema, table, rowData, knownColumns, conflictKey, "")
 	if err != nil {
 		return nil, err
 	}
@@ -315,7 +752,7 @@ func (op *opLogParser) generateTableDDLAndInsertForNestedObject(schema, table, p
 	return statements, nil
 }
 
-func prepareAlterStatement(schema, table string, newFields map[string]any) (string, error) {
+func (op *opLogParser) prepareAlterStatement(schema, table string, newFields map[string]any) (string, error) {
 	var fields []string
 	for field := range newFields {
 		fields = append(fields, field)
@@ -324,18 +761,24 @@ func prepareAlterStatement(schema, table string, newFields map[string]any) (stri
 
 	var columnDefinitions []string
 	for _, col := range fields {
-		sqlType, err := getSqlType(col, newFields[col])
+		sqlType, err := op.getSqlType(col, newFields[col])
 		if err != nil {
 			return "", err
 		}
 
-		columnDefinitions = append(columnDefinitions, fmt.Sprintf("%s %s", col, sqlType))
+		columnDefinitions = append(columnDefinitions, fmt.Sprintf("%s %s", op.ident(col), sqlType))
 	}
 
-	return fmt.Sprintf("ALTER TABLE %s.%s ADD %s;", schema, table, strings.Join(columnDefinitions, ", ")), nil
+	if op.config.Upsert {
+		return fmt.Sprintf("ALTER TABLE %s ADD COLUMN IF NOT EXISTS %s;", op.qualifiedName(schema, table),
+			strings.Join(columnDefinitions, ", ADD COLUMN IF NOT EXISTS ")), nil
+	}
+	return fmt.Sprintf("ALTER TABLE %s ADD %s;", op.qualifiedName(schema, table), strings.Join(columnDefinitions, ", ")), nil
 }
 
-func prepareInsertStatement(schema, table string, data map[string]any, knownColumns map[string]bool) (string, error) {
+// prepareInsertStatement renders the insert of data. A non-empty conflictKey
+// makes it upsert rows matching on those columns.
+func (op *opLogParser) prepareInsertStatement(schema, table string, data map[string]any, knownColumns map[string]bool, conflictKey []string, guard string) (string, error) {
 	if len(data) == 0 {
 		return "", fmt.Errorf("empty data field for insert")
 	}
@@ -350,30 +793,85 @@ func prepareInsertStatement(schema, table string, data map[string]any, knownColu
 		value, ok := data[col]
 		if !ok {
 			values = append(values, fieldNull)
-		} else {
-			values = append(values, formatValue(value))
+			continue
 		}
+		literal, err := formatValue(value)
+		if err != nil {
+			return "", fmt.Errorf("field %s: %v", col, err)
+		}
+		values = append(values, literal)
 	}
 
+	quotedColumns := make([]string, len(columns))
+	for i, col := range columns {
+		quotedColumns[i] = op.ident(col)
+	}
+
+	var conflict string
+	if len(conflictKey) > 0 {
+		updateColumns := quotedColumns
+		if _, ok := data[fieldOplogTS]; !ok && knownColumns[fieldOplogTS] {
+			// An entry without ts keeps the ts of the existing row
+			updateColumns = slices.DeleteFunc(slices.Clone(quotedColumns), func(col string) bool {
+				return col == op.ident(fieldOplogTS)
+			})
+		}
+		conflict = op.conflictClause(conflictKey, updateColumns, guard)
+	}
 	statement := fmt.Sprintf(
-		"INSERT INTO %s.%s (%s) VALUES (%s);",
-		schema,
-		table,
-		strings.Join(columns, ", "),
+		"INSERT INTO %s (%s) VALUES (%s)%s;",
+		op.qualifiedName(schema, table),
+		strings.Join(quotedColumns, ", "),
 		strings.Join(values, ", "),
+		conflict,
 	)
 	return statement, nil
 }
 
+// createClause returns the CREATE prefix for the given object kind, made
+// idempotent in upsert mode.
+func (op *opLogParser) createClause(kind string) string {
+	if op.config.Upsert {
+		return fmt.Sprintf("CREATE %s IF NOT EXISTS", kind)
+	}
+	return fmt.Sprintf("CREATE %s", kind)
+}
+
+// conflictClause returns the ON CONFLICT suffix of an upserting insert so
+// replaying the same oplog updates rows instead of failing on the primary key.
+// columns are expected to be quoted already. A non-empty guard restricts the
+// update to the rows matching it.
+func (op *opLogParser) conflictClause(key, columns []string, guard string) string {
+	isKey := make(map[string]bool, len(key))
+	for _, col := range key {
+		isKey[op.ident(col)] = true
+	}
+	var updates []string
+	for _, col := range columns {
+		if !isKey[col] {
+			updates = append(updates, fmt.Sprintf("%s = EXCLUDED.%s", col, col))
+		}
+	}
+	if len(updates) == 0 {
+		return fmt.Sprintf(" ON CONFLICT (%s) DO NOTHING", op.identList(key))
+	}
+	clause := fmt.Sprintf(" ON CONFLICT (%s) DO UPDATE SET %s", op.identList(key), strings.Join(updates, ", "))
+	if guard != "" {
+		clause += " WHERE " + guard
+	}
+	return clause
+}
+
 func parseNamespace(namespace string) (schema, table string, err error) {
-	parts := strings.Split(namespace, ".")
+	// Collection names may contain dots, the database name cannot.
+	parts := strings.SplitN(namespace, ".", 2)
 	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
 		return "", "", fmt.Errorf("error parsing namespace, invalid namespace")
 	}
 	return parts[0], parts[1], nil
 }
 
-func prepareNestedTableDDL(schema, table string, data map[string]any, parentTable, referenceTableId string) (tableStatement string, err error) {
+func (op *opLogParser) prepareNestedTableDDL(schema, table string, data map[string]any, parentColumns []string) (tableStatement string, err error) {
 	var columns []string
 	for colName := range data {
 		columns = append(columns, colName)
@@ -382,24 +880,30 @@ func prepareNestedTableDDL(schema, table string, data map[string]any, parentTabl
 
 	var tableFields []string
 	for _, colName := range columns {
-		var value any
-		if colName == fmt.Sprintf("%s__id", parentTable) {
-			value = referenceTableId
-		} else {
-			value = data[colName]
-		}
-		sqlType, err := getSqlType(colName, value)
+		sqlType, err := op.getSqlType(colName, data[colName])
 		if err != nil {
 			return "", err
 		}
-		tableFields = append(tableFields, fmt.Sprintf("%s %s", colName, sqlType))
+		tableFields = append(tableFields, fmt.Sprintf("%s %s", op.ident(colName), sqlType))
+	}
+	if _, ok := data[fieldPosition]; ok {
+		tableFields = append(tableFields, fmt.Sprintf("UNIQUE (%s)", op.identList(append(parentColumns, fieldPosition))))
 	}
-	tableStatement = fmt.Sprintf("CREATE TABLE %s.%s (%s);", schema, table, strings.Join(tableFields, ", "))
+	tableStatement = fmt.Sprintf("%s %s (%s);", op.createClause("TABLE"), op.qualifiedName(schema, table), strings.Join(tableFields, ", "))
 
 	return tableStatement, nil
 }
 
-func prepareTableDDL(schema, table string, data map[string]any) (tableStatement string, err error) {
+// positionIndexStatement adds the uniqueness of the element position per
+// parent row to a child table that got its _position column after creation.
+func (op *opLogParser) positionIndexStatement(schema, table string, parentColumns []string) string {
+	key := append(slices.Clone(parentColumns), fieldPosition)
+	name := fmt.Sprintf("%s_%s_key", table, strings.Join(key, "_"))
+	return fmt.Sprintf("%s %s ON %s (%s);", op.createClause("UNIQUE INDEX"), op.ident(name),
+		op.qualifiedName(schema, table), op.identList(key))
+}
+
+func (op *opLogParser) prepareTableDDL(schema, table string, data map[string]any) (tableStatement string, err error) {
 	var columns []string
 	for colName := range data {
 		columns = append(columns, colName)
@@ -409,46 +913,98 @@ func prepareTableDDL(schema, table string, data map[string]any) (tableStatement
 	var tableFields []string
 	for _, colName := range columns {
 		value := data[colName]
-		sqlType, err := getSqlType(colName, value)
+		sqlType, err := op.getSqlType(colName, value)
 		if err != nil {
 			return "", err
 		}
-		tableFields = append(tableFields, fmt.Sprintf("%s %s", colName, sqlType))
+		tableFields = append(tableFields, fmt.Sprintf("%s %s", op.ident(colName), sqlType))
 	}
-	tableStatement = fmt.Sprintf("CREATE TABLE %s.%s (%s);", schema, table, strings.Join(tableFields, ", "))
+	if key := keyColumns(data); len(key) > 0 && key[0] != fieldID {
+		tableFields = append(tableFields, fmt.Sprintf("PRIMARY KEY (%s)", op.identList(key)))
+	}
+	tableStatement = fmt.Sprintf("%s %s (%s);", op.createClause("TABLE"), op.qualifiedName(schema, table), strings.Join(tableFields, ", "))
 
 	return tableStatement, nil
 }
 
+// getColumnTypes returns the SQL type of every column in data, without the
+// primary key constraint, as recorded by the schema tracker.
+func (op *opLogParser) getColumnTypes(data map[string]any) (map[string]string, error) {
+	columnTypes := make(map[string]string, len(data))
+	for col, value := range data {
+		sqlType, err := op.getSqlType(col, value)
+		if err != nil {
+			return nil, err
+		}
+		columnTypes[col] = strings.TrimSuffix(sqlType, primaryKey)
+	}
+	return columnTypes, nil
+}
+
+// typedColumns drops the null valued columns from data unless a default type
+// for nulls is configured. Such columns stay pending until a value arrives.
+func (op *opLogParser) typedColumns(data map[string]any) map[string]any {
+	if op.config.NullColumnType != "" {
+		return data
+	}
+	typed := make(map[string]any, len(data))
+	for col, value := range data {
+		if value != nil {
+			typed[col] = value
+		}
+	}
+	return typed
+}
+
+func (op *opLogParser) recordNullColumns(namespace string, data map[string]any) error {
+	var columns []string
+	for col, value := range data {
+		if value == nil {
+			columns = append(columns, col)
+		}
+	}
+	if len(columns) == 0 {
+		return nil
+	}
+	sort.Strings(columns)
+	return op.tracker.MarkColumnsNullable(namespace, columns)
+}
+
+func (op *opLogParser) getSqlType(fieldName string, value any) (string, error) {
+	if value == nil && fieldName != fieldID && op.config.NullColumnType != "" {
+		return op.config.NullColumnType, nil
+	}
+	return getSqlType(fieldName, value)
+}
+
 func getSqlType(fieldName string, value any) (string, error) {
 	if fieldName == fieldID {
-		return "VARCHAR(255) PRIMARY KEY", nil
+		if id, ok := value.(idValue); ok {
+			sqlType, err := idSqlType(id.value)
+			return sqlType + primaryKey, err
+		}
+		return "VARCHAR(255)" + primaryKey, nil
+	}
+	if fieldName == fieldPosition {
+		return "INTEGER", nil
 	}
 
-	switch value.(type) {
+	switch val := value.(type) {
 	case string:
 		return "VARCHAR(255)", nil
 	case bool:
 		return "BOOLEAN", nil
 	case float64, int64, int32, int16, int8:
 		return "FLOAT", nil
+	case []byte:
+		return "BYTEA", nil
+	case jsonValue:
+		return "JSONB", nil
+	case idValue:
+		return idSqlType(val.value)
+	case sqlExpr:
+		return val.sqlType, nil
 	default:
 		return "", fmt.Errorf("error converting: %v to sql type for field %v, type: %T", value, fieldName, value)
 	}
 }
-
-func formatValue(v any) string {
-	switch val := v.(type) {
-	case string:
-		return fmt.Sprintf("'%s'", val)
-	case bool:
-		return fmt.Sprintf("%t", val)
-	case float64:
-		if val == float64(int(val)) {
-			return fmt.Sprintf("%d", int(val))
-		}
-		return fmt.Sprintf("%f", val)
-	default:
-		return fmt.Sprintf("'%v'", val)
-	}
-}
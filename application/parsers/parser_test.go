@@ -1,10 +1,21 @@
 package parsers
 
 import (
+	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
+	"op-log-parser/application/domain/models"
+	"op-log-parser/application/persistence/file"
+	"op-log-parser/application/persistence/memory"
+	"path/filepath"
 	"reflect"
 	"testing"
+
+	googleuuid "github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 const uuid = "random-uuid"
@@ -47,7 +58,7 @@ func TestParse(t *testing.T) {
 			expectedSQL: []string{
 				"CREATE SCHEMA test;",
 				"CREATE TABLE test.student (_id VARCHAR(255) PRIMARY KEY, age FLOAT, date_of_birth VARCHAR(255), is_graduated BOOLEAN, name VARCHAR(255), roll_no FLOAT, score FLOAT);",
-				"INSERT INTO test.student (_id, age, date_of_birth, is_graduated, name, roll_no, score) VALUES ('635b79e231d82a8ab1de863b', 23, '2000-01-30', false, 'Selena O'Malley', 51, 95.500000);",
+				"INSERT INTO test.student (_id, age, date_of_birth, is_graduated, name, roll_no, score) VALUES ('635b79e231d82a8ab1de863b', 23, '2000-01-30', false, 'Selena O''Malley', 51, 95.5);",
 				"INSERT INTO test.student (_id, age, date_of_birth, is_graduated, name, roll_no, score) VALUES ('123b79e231d82a8ab1de863b', 24, '2001-01-30', false, 'Ramesh Ramesh', 52, 80);"},
 			expectedErr: nil,
 		},
@@ -100,11 +111,11 @@ func TestParse(t *testing.T) {
 			expectedSQL: []string{
 				"CREATE SCHEMA test;",
 				"CREATE TABLE test.student (_id VARCHAR(255) PRIMARY KEY, age FLOAT, date_of_birth VARCHAR(255), is_graduated BOOLEAN, name VARCHAR(255), roll_no FLOAT, score FLOAT);",
-				"INSERT INTO test.student (_id, age, date_of_birth, is_graduated, name, roll_no, score) VALUES ('635b79e231d82a8ab1de863b', 23, '2000-01-30', false, 'Selena O'Malley', 51, 95.500000);",
+				"INSERT INTO test.student (_id, age, date_of_birth, is_graduated, name, roll_no, score) VALUES ('635b79e231d82a8ab1de863b', 23, '2000-01-30', false, 'Selena O''Malley', 51, 95.5);",
 				"ALTER TABLE test.student ADD gender VARCHAR(255);",
 				"INSERT INTO test.student (_id, age, date_of_birth, gender, is_graduated, name, roll_no, score) VALUES ('123b79e231d82a8ab1de863b', 24, '2001-01-30', 'Male', false, 'Ramesh Ramesh', 52, 80);",
 				"ALTER TABLE test.student ADD height FLOAT, weight FLOAT;",
-				"INSERT INTO test.student (_id, age, date_of_birth, gender, height, is_graduated, name, roll_no, score, weight) VALUES ('098b79e231d82a8ab1de863b', 110, '1920-01-30', 'Male', 6.100000, true, 'Superman', 1, 100, 90);",
+				"INSERT INTO test.student (_id, age, date_of_birth, gender, height, is_graduated, name, roll_no, score, weight) VALUES ('098b79e231d82a8ab1de863b', 110, '1920-01-30', 'Male', 6.1, true, 'Superman', 1, 100, 90);",
 			},
 			expectedErr: nil,
 		},
@@ -304,7 +315,7 @@ func TestParse(t *testing.T) {
 			uuidGenerator := func() string {
 				return uuid
 			}
-			parser := NewParser(uuidGenerator)
+			parser := NewParser(uuidGenerator, memory.NewSchemaTracker())
 			actualSQL, err := parser.Parse(tc.inputJSON)
 
 			if tc.expectedErr != nil {
@@ -325,3 +336,1060 @@ func TestParse(t *testing.T) {
 		})
 	}
 }
+
+func TestParseWithConfig(t *testing.T) {
+	testCases := []struct {
+		name        string
+		config      Config
+		tracker     *memory.SchemaTracker
+		inputJSON   string
+		expectedSQL []string
+		expectedErr error
+	}{
+		{
+			name: "Field rules: exclude, hash, mask and truncate on insert",
+			config: Config{
+				HashSalt: "pepper",
+				Namespaces: map[string]NamespaceConfig{
+					"test.student": {Fields: FieldRules{
+						Exclude:  []string{"email"},
+						Hash:     []string{"phone.personal"},
+						Mask:     []string{"address.zip"},
+						Truncate: map[string]int{"name": 6},
+					}},
+				},
+			},
+			inputJSON: `[{
+				"op": "i",
+				"ns": "test.student",
+				"o": {
+					"_id": "635b79e231d82a8ab1de863b",
+					"name": "Selena Miller",
+					"email": "selena@example.com",
+					"address": [{"line1": "481 Harborsburgh", "zip": "89799"}],
+					"phone": {"personal": "7678456640", "work": "8130097989"}
+				}
+			}]`,
+			expectedSQL: []string{
+				"CREATE SCHEMA test;",
+				"CREATE TABLE test.student (_id VARCHAR(255) PRIMARY KEY, name VARCHAR(255));",
+				"CREATE TABLE test.student_phone (_id VARCHAR(255) PRIMARY KEY, personal VARCHAR(255), student__id VARCHAR(255), work VARCHAR(255));",
+				"INSERT INTO test.student_phone (_id, personal, student__id, work) VALUES ('random-uuid', '48fd373e08cae69c28fb9ca3e252c6ebbc00b9e23ef91e778795ae85004b4078', '635b79e231d82a8ab1de863b', '8130097989');",
+				"CREATE TABLE test.student_address (_id VARCHAR(255) PRIMARY KEY, line1 VARCHAR(255), student__id VARCHAR(255), zip VARCHAR(255));",
+				"INSERT INTO test.student_address (_id, line1, student__id, zip) VALUES ('random-uuid', '481 Harborsburgh', '635b79e231d82a8ab1de863b', '****');",
+				"INSERT INTO test.student (_id, name) VALUES ('635b79e231d82a8ab1de863b', 'Selena');",
+			},
+		},
+		{
+			name: "Field rules: include only keeps listed paths",
+			config: Config{
+				Namespaces: map[string]NamespaceConfig{
+					"test.student": {Fields: FieldRules{Include: []string{"name", "phone.work"}}},
+				},
+			},
+			inputJSON: `[{
+				"op": "i",
+				"ns": "test.student",
+				"o": {
+					"_id": "635b79e231d82a8ab1de863b",
+					"name": "Selena Miller",
+					"roll_no": 51,
+					"phone": {"personal": "7678456640", "work": "8130097989"}
+				}
+			}]`,
+			expectedSQL: []string{
+				"CREATE SCHEMA test;",
+				"CREATE TABLE test.student (_id VARCHAR(255) PRIMARY KEY, name VARCHAR(255));",
+				"CREATE TABLE test.student_phone (_id VARCHAR(255) PRIMARY KEY, student__id VARCHAR(255), work VARCHAR(255));",
+				"INSERT INTO test.student_phone (_id, student__id, work) VALUES ('random-uuid', '635b79e231d82a8ab1de863b', '8130097989');",
+				"INSERT INTO test.student (_id, name) VALUES ('635b79e231d82a8ab1de863b', 'Selena Miller');",
+			},
+		},
+		{
+			name: "Field rules: applied to update set and unset fields",
+			config: Config{
+				Namespaces: map[string]NamespaceConfig{
+					"test.student": {Fields: FieldRules{Exclude: []string{"email", "notes"}, Mask: []string{"name"}}},
+				},
+			},
+			inputJSON: `[{
+				"op": "u",
+				"ns": "test.student",
+				"o": {"diff": {"u": {"name": "New Name", "email": "x@example.com"}, "d": {"notes": true}}},
+				"o2": {"_id": "id123"}
+			},
+			{
+				"op": "u",
+				"ns": "test.student",
+				"o": {"diff": {"u": {"email": "y@example.com"}}},
+				"o2": {"_id": "id123"}
+			}]`,
+			expectedSQL: []string{"UPDATE test.student SET name = '****' WHERE _id = 'id123';"},
+		},
+		{
+			name:   "Upsert: idempotent DDL and conflict handling",
+			config: Config{Upsert: true},
+			inputJSON: `[{
+				"op": "i",
+				"ns": "test.student",
+				"o": {"_id": "635b79e231d82a8ab1de863b", "name": "Selena Miller", "roll_no": 51}
+			},
+			{
+				"op": "i",
+				"ns": "test.student",
+				"o": {"_id": "635b79e231d82a8ab1de863b", "name": "Selena Miller", "roll_no": 51, "gender": "Female", "is_graduated": false}
+			},
+			{
+				"op": "i",
+				"ns": "test.empty",
+				"o": {"_id": "1"}
+			}]`,
+			expectedSQL: []string{
+				"CREATE SCHEMA IF NOT EXISTS test;",
+				"CREATE TABLE IF NOT EXISTS test.student (_id VARCHAR(255) PRIMARY KEY, name VARCHAR(255), roll_no FLOAT);",
+				"INSERT INTO test.student (_id, name, roll_no) VALUES ('635b79e231d82a8ab1de863b', 'Selena Miller', 51) ON CONFLICT (_id) DO UPDATE SET name = EXCLUDED.name, roll_no = EXCLUDED.roll_no;",
+				"ALTER TABLE test.student ADD COLUMN IF NOT EXISTS gender VARCHAR(255), ADD COLUMN IF NOT EXISTS is_graduated BOOLEAN;",
+				"INSERT INTO test.student (_id, gender, is_graduated, name, roll_no) VALUES ('635b79e231d82a8ab1de863b', 'Female', false, 'Selena Miller', 51) ON CONFLICT (_id) DO UPDATE SET gender = EXCLUDED.gender, is_graduated = EXCLUDED.is_graduated, name = EXCLUDED.name, roll_no = EXCLUDED.roll_no;",
+				"CREATE TABLE IF NOT EXISTS test.empty (_id VARCHAR(255) PRIMARY KEY);",
+				"INSERT INTO test.empty (_id) VALUES ('1') ON CONFLICT (_id) DO NOTHING;",
+			},
+		},
+		{
+			name:   "Child ids: deterministic strategy is stable across replays",
+			config: Config{ChildIDStrategy: ChildIDDeterministic},
+			inputJSON: `[{
+				"op": "i",
+				"ns": "test.student",
+				"o": {
+					"_id": "1",
+					"phone": {"personal": "7678456640"},
+					"address": [{"zip": "89799"}, {"zip": "80872"}]
+				}
+			},
+			{
+				"op": "i",
+				"ns": "test.student",
+				"o": {
+					"_id": "1",
+					"phone": {"personal": "7678456640"},
+					"address": [{"zip": "89799"}, {"zip": "80872"}]
+				}
+			}]`,
+			expectedSQL: []string{
+				"CREATE SCHEMA test;",
+				"CREATE TABLE test.student (_id VARCHAR(255) PRIMARY KEY);",
+				"CREATE TABLE test.student_phone (_id VARCHAR(255) PRIMARY KEY, personal VARCHAR(255), student__id VARCHAR(255));",
+				"INSERT INTO test.student_phone (_id, personal, student__id) VALUES ('0014f043-1497-579f-9273-05f2c3e5a3db', '7678456640', '1');",
+				"CREATE TABLE test.student_address (_id VARCHAR(255) PRIMARY KEY, student__id VARCHAR(255), zip VARCHAR(255));",
+				"INSERT INTO test.student_address (_id, student__id, zip) VALUES ('03d47a0a-60f8-50c0-aab6-e3f97b18bb2f', '1', '89799');",
+				"INSERT INTO test.student_address (_id, student__id, zip) VALUES ('2837f95f-ee2f-51c3-bd33-b10d4e4ae0b3', '1', '80872');",
+				"INSERT INTO test.student (_id) VALUES ('1');",
+				"INSERT INTO test.student_phone (_id, personal, student__id) VALUES ('0014f043-1497-579f-9273-05f2c3e5a3db', '7678456640', '1');",
+				"INSERT INTO test.student_address (_id, student__id, zip) VALUES ('03d47a0a-60f8-50c0-aab6-e3f97b18bb2f', '1', '89799');",
+				"INSERT INTO test.student_address (_id, student__id, zip) VALUES ('2837f95f-ee2f-51c3-bd33-b10d4e4ae0b3', '1', '80872');",
+				"INSERT INTO test.student (_id) VALUES ('1');",
+			},
+		},
+		{
+			name:   "Array position: child rows keep their index",
+			config: Config{ArrayPositionColumn: true},
+			inputJSON: `[{
+				"op": "i",
+				"ns": "test.student",
+				"o": {
+					"_id": "1",
+					"address": [{"zip": "89799"}, {"zip": "80872"}]
+				}
+			}]`,
+			expectedSQL: []string{
+				"CREATE SCHEMA test;",
+				"CREATE TABLE test.student (_id VARCHAR(255) PRIMARY KEY);",
+				"CREATE TABLE test.student_address (_id VARCHAR(255) PRIMARY KEY, _position INTEGER, student__id VARCHAR(255), zip VARCHAR(255), UNIQUE (student__id, _position));",
+				"INSERT INTO test.student_address (_id, _position, student__id, zip) VALUES ('random-uuid', 0, '1', '89799');",
+				"INSERT INTO test.student_address (_id, _position, student__id, zip) VALUES ('random-uuid', 1, '1', '80872');",
+				"INSERT INTO test.student (_id) VALUES ('1');",
+			},
+		},
+		{
+			name: "Nulls: columns stay pending until a value shows up",
+			inputJSON: `[{
+				"op": "i",
+				"ns": "test.student",
+				"o": {"_id": "1", "name": "Selena", "middle_name": null}
+			},
+			{
+				"op": "u",
+				"ns": "test.student",
+				"o": {"diff": {"u": {"name": "Sel", "nickname": null}}},
+				"o2": {"_id": "1"}
+			},
+			{
+				"op": "u",
+				"ns": "test.student",
+				"o": {"diff": {"u": {"middle_name": "Grace"}}},
+				"o2": {"_id": "1"}
+			},
+			{
+				"op": "i",
+				"ns": "test.student",
+				"o": {"_id": "2", "name": "Ramesh", "middle_name": null}
+			}]`,
+			expectedSQL: []string{
+				"CREATE SCHEMA test;",
+				"CREATE TABLE test.student (_id VARCHAR(255) PRIMARY KEY, name VARCHAR(255));",
+				"INSERT INTO test.student (_id, name) VALUES ('1', 'Selena');",
+				"UPDATE test.student SET name = 'Sel' WHERE _id = '1';",
+				"ALTER TABLE test.student ADD middle_name VARCHAR(255);",
+				"UPDATE test.student SET middle_name = 'Grace' WHERE _id = '1';",
+				"INSERT INTO test.student (_id, middle_name, name) VALUES ('2', NULL, 'Ramesh');",
+			},
+		},
+		{
+			name:   "Nulls: default type for columns first seen as null",
+			config: Config{NullColumnType: "TEXT"},
+			inputJSON: `[{
+				"op": "i",
+				"ns": "test.student",
+				"o": {"_id": "1", "middle_name": null}
+			}]`,
+			expectedSQL: []string{
+				"CREATE SCHEMA test;",
+				"CREATE TABLE test.student (_id VARCHAR(255) PRIMARY KEY, middle_name TEXT);",
+				"INSERT INTO test.student (_id, middle_name) VALUES ('1', NULL);",
+			},
+		},
+		{
+			name: "Arrays: empty arrays are skipped and mixed arrays fall back to JSONB",
+			inputJSON: `[{
+				"op": "i",
+				"ns": "test.student",
+				"o": {"_id": "1", "tags": [], "notes": [{"text": "it's fine"}, "plain"]}
+			},
+			{
+				"op": "u",
+				"ns": "test.student",
+				"o": {"diff": {"u": {"tags": [], "notes": ["only", {"text": "x"}]}}},
+				"o2": {"_id": "1"}
+			}]`,
+			expectedSQL: []string{
+				"CREATE SCHEMA test;",
+				"CREATE TABLE test.student (_id VARCHAR(255) PRIMARY KEY, notes JSONB);",
+				`INSERT INTO test.student (_id, notes) VALUES ('1', '[{"text":"it''s fine"},"plain"]'::jsonb);`,
+				`UPDATE test.student SET notes = '["only",{"text":"x"}]'::jsonb WHERE _id = '1';`,
+			},
+		},
+		{
+			name: "Arrays: scalar arrays are stored as JSONB",
+			inputJSON: `[{
+				"op": "i",
+				"ns": "test.student",
+				"o": {"_id": "1", "tags": ["x", "y"]}
+			},
+			{
+				"op": "u",
+				"ns": "test.student",
+				"o": {"diff": {"u": {"tags": [1, 2]}}},
+				"o2": {"_id": "1"}
+			}]`,
+			expectedSQL: []string{
+				"CREATE SCHEMA test;",
+				"CREATE TABLE test.student (_id VARCHAR(255) PRIMARY KEY, tags JSONB);",
+				`INSERT INTO test.student (_id, tags) VALUES ('1', '["x","y"]'::jsonb);`,
+				`UPDATE test.student SET tags = '[1,2]'::jsonb WHERE _id = '1';`,
+			},
+		},
+		{
+			name:   "Arrays: mixed arrays as child value table",
+			config: Config{MixedArrayFallback: MixedArrayChildValues},
+			inputJSON: `[{
+				"op": "i",
+				"ns": "test.student",
+				"o": {"_id": "1", "notes": [{"text": "hello"}, "plain", 3]}
+			}]`,
+			expectedSQL: []string{
+				"CREATE SCHEMA test;",
+				"CREATE TABLE test.student (_id VARCHAR(255) PRIMARY KEY);",
+				"CREATE TABLE test.student_notes (_id VARCHAR(255) PRIMARY KEY, student__id VARCHAR(255), value VARCHAR(255));",
+				`INSERT INTO test.student_notes (_id, student__id, value) VALUES ('random-uuid', '1', '{"text":"hello"}');`,
+				"INSERT INTO test.student_notes (_id, student__id, value) VALUES ('random-uuid', '1', 'plain');",
+				"INSERT INTO test.student_notes (_id, student__id, value) VALUES ('random-uuid', '1', '3');",
+				"INSERT INTO test.student (_id) VALUES ('1');",
+			},
+		},
+		{
+			name:   "Identifiers: snake case, reserved words and name collisions",
+			config: Config{Identifiers: IdentifierPolicy{SnakeCase: true}},
+			inputJSON: `[{
+				"op": "i",
+				"ns": "test.userProfiles",
+				"o": {"_id": "1", "firstName": "Selena", "first_name": "dup", "home address": "x", "order": 3}
+			},
+			{
+				"op": "u",
+				"ns": "test.userProfiles",
+				"o": {"diff": {"u": {"firstName": "Sel", "first_name": "d2"}}},
+				"o2": {"_id": "1"}
+			},
+			{
+				"op": "d",
+				"ns": "test.userProfiles",
+				"o": {"_id": "1"}
+			}]`,
+			expectedSQL: []string{
+				"CREATE SCHEMA test;",
+				`CREATE TABLE test.user_profiles (_id VARCHAR(255) PRIMARY KEY, first_name VARCHAR(255), first_name_2 VARCHAR(255), home_address VARCHAR(255), "order" FLOAT);`,
+				`INSERT INTO test.user_profiles (_id, first_name, first_name_2, home_address, "order") VALUES ('1', 'dup', 'Selena', 'x', 3);`,
+				"UPDATE test.user_profiles SET first_name = 'd2', first_name_2 = 'Sel' WHERE _id = '1';",
+				"DELETE FROM test.user_profiles WHERE _id = '1';",
+			},
+		},
+		{
+			name: "Identifiers: fields named like internal columns get other columns",
+			config: Config{
+				ArrayPositionColumn: true,
+				MetadataColumns:     true,
+				Namespaces:          map[string]NamespaceConfig{"test.student": {SoftDelete: true}},
+			},
+			inputJSON: `[{
+				"op": "i",
+				"ns": "test.student",
+				"o": {"_id": "1", "_deleted": "yes", "_source_ns": "x", "exams": [{"_position": "first", "student__id": "2"}]}
+			}]`,
+			expectedSQL: []string{
+				"CREATE SCHEMA test;",
+				"CREATE TABLE test.student (_deleted BOOLEAN, _deleted_2 VARCHAR(255), _deleted_at TIMESTAMPTZ, _deleted_ts BIGINT, _id VARCHAR(255) PRIMARY KEY, _oplog_op VARCHAR(10), _source_ns VARCHAR(255), _source_ns_2 VARCHAR(255), _synced_at TIMESTAMPTZ);",
+				"CREATE TABLE test.student_exams (_id VARCHAR(255) PRIMARY KEY, _position INTEGER, _position_2 VARCHAR(255), student__id VARCHAR(255), student__id_2 VARCHAR(255), UNIQUE (student__id, _position));",
+				"INSERT INTO test.student_exams (_id, _position, _position_2, student__id, student__id_2) VALUES ('9b20b5e3-f746-5bb6-9ab0-7f2a55f9c831', 0, 'first', '1', '2') ON CONFLICT (student__id, _position) DO UPDATE SET _id = EXCLUDED._id, _position_2 = EXCLUDED._position_2, student__id_2 = EXCLUDED.student__id_2;",
+				"INSERT INTO test.student (_deleted, _deleted_2, _deleted_at, _deleted_ts, _id, _oplog_op, _source_ns, _source_ns_2, _synced_at) VALUES (false, 'yes', NULL, NULL, '1', 'insert', 'test.student', 'x', now()) ON CONFLICT (_id) DO UPDATE SET _deleted = EXCLUDED._deleted, _deleted_2 = EXCLUDED._deleted_2, _deleted_at = EXCLUDED._deleted_at, _deleted_ts = EXCLUDED._deleted_ts, _oplog_op = EXCLUDED._oplog_op, _source_ns = EXCLUDED._source_ns, _source_ns_2 = EXCLUDED._source_ns_2, _synced_at = EXCLUDED._synced_at;",
+			},
+		},
+		{
+			name:   "Identifiers: always quoted and truncated to the PostgreSQL limit",
+			config: Config{Identifiers: IdentifierPolicy{Quote: QuoteAlways}},
+			inputJSON: `[{
+				"op": "i",
+				"ns": "test.student",
+				"o": {"_id": "1", "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa": "v", "Last-Name": "Miller"}
+			}]`,
+			expectedSQL: []string{
+				`CREATE SCHEMA "test";`,
+				`CREATE TABLE "test"."student" ("Last-Name" VARCHAR(255), "_id" VARCHAR(255) PRIMARY KEY, "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa_6bd5e503" VARCHAR(255));`,
+				`INSERT INTO "test"."student" ("Last-Name", "_id", "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa_6bd5e503") VALUES ('Miller', '1', 'v');`,
+			},
+		},
+		{
+			name: "Typed _id: numeric and ObjectId ids",
+			inputJSON: `[{
+				"op": "i",
+				"ns": "test.counter",
+				"o": {"_id": 42, "name": "a", "tags": [{"label": "x"}]}
+			},
+			{
+				"op": "u",
+				"ns": "test.counter",
+				"o": {"diff": {"u": {"name": "b"}}},
+				"o2": {"_id": 42}
+			},
+			{
+				"op": "d",
+				"ns": "test.counter",
+				"o": {"_id": 42}
+			},
+			{
+				"op": "i",
+				"ns": "test.student",
+				"o": {"_id": {"$oid": "635b79e231d82a8ab1de863b"}, "name": "Selena"}
+			},
+			{
+				"op": "u",
+				"ns": "test.student",
+				"o": {"diff": {"u": {"name": "Sel"}}},
+				"o2": {"_id": {"$oid": "635b79e231d82a8ab1de863b"}}
+			}]`,
+			expectedSQL: []string{
+				"CREATE SCHEMA test;",
+				"CREATE TABLE test.counter (_id BIGINT PRIMARY KEY, name VARCHAR(255));",
+				"CREATE TABLE test.counter_tags (_id VARCHAR(255) PRIMARY KEY, counter__id BIGINT, label VARCHAR(255));",
+				"INSERT INTO test.counter_tags (_id, counter__id, label) VALUES ('random-uuid', 42, 'x');",
+				"INSERT INTO test.counter (_id, name) VALUES (42, 'a');",
+				"UPDATE test.counter SET name = 'b' WHERE _id = 42;",
+				"DELETE FROM test.counter WHERE _id = 42;",
+				"CREATE TABLE test.student (_id VARCHAR(255) PRIMARY KEY, name VARCHAR(255));",
+				"INSERT INTO test.student (_id, name) VALUES ('635b79e231d82a8ab1de863b', 'Selena');",
+				"UPDATE test.student SET name = 'Sel' WHERE _id = '635b79e231d82a8ab1de863b';",
+			},
+		},
+		{
+			name:   "Typed _id: composite id maps to a multi-column primary key",
+			config: Config{Upsert: true, ArrayPositionColumn: true},
+			inputJSON: `[{
+				"op": "i",
+				"ns": "test.enrollment",
+				"o": {"_id": {"student": "s1", "year": 2024}, "grade": "A", "exams": [{"score": 9}]}
+			},
+			{
+				"op": "u",
+				"ns": "test.enrollment",
+				"o": {"diff": {"u": {"grade": "B"}}},
+				"o2": {"_id": {"student": "s1", "year": 2024}}
+			},
+			{
+				"op": "d",
+				"ns": "test.enrollment",
+				"o": {"_id": {"student": "s1", "year": 2024}}
+			}]`,
+			expectedSQL: []string{
+				"CREATE SCHEMA IF NOT EXISTS test;",
+				"CREATE TABLE IF NOT EXISTS test.enrollment (_id_student VARCHAR(255), _id_year BIGINT, grade VARCHAR(255), PRIMARY KEY (_id_student, _id_year));",
+				"CREATE TABLE IF NOT EXISTS test.enrollment_exams (_id VARCHAR(255) PRIMARY KEY, _position INTEGER, enrollment__id_student VARCHAR(255), enrollment__id_year BIGINT, score FLOAT, UNIQUE (enrollment__id_student, enrollment__id_year, _position));",
+				"INSERT INTO test.enrollment_exams (_id, _position, enrollment__id_student, enrollment__id_year, score) VALUES ('14ed3e9e-11f1-53ca-86fd-9bb3a951fd85', 0, 's1', 2024, 9) ON CONFLICT (enrollment__id_student, enrollment__id_year, _position) DO UPDATE SET _id = EXCLUDED._id, score = EXCLUDED.score;",
+				"INSERT INTO test.enrollment (_id_student, _id_year, grade) VALUES ('s1', 2024, 'A') ON CONFLICT (_id_student, _id_year) DO UPDATE SET grade = EXCLUDED.grade;",
+				"UPDATE test.enrollment SET grade = 'B' WHERE _id_student = 's1' AND _id_year = 2024;",
+				"DELETE FROM test.enrollment WHERE _id_student = 's1' AND _id_year = 2024;",
+			},
+		},
+		{
+			name: "Soft delete: delete marks the row and a re-insert clears it",
+			config: Config{Namespaces: map[string]NamespaceConfig{
+				"test.student": {SoftDelete: true},
+			}},
+			inputJSON: `[{
+				"op": "i",
+				"ns": "test.student",
+				"o": {"_id": "1", "name": "Selena"},
+				"ts": {"T": 1685687329, "I": 2}
+			},
+			{
+				"op": "d",
+				"ns": "test.student",
+				"o": {"_id": "1"},
+				"ts": {"T": 1685687400, "I": 1}
+			},
+			{
+				"op": "i",
+				"ns": "test.student",
+				"o": {"_id": "1", "name": "Selena"},
+				"ts": {"$timestamp": {"t": 1685687500, "i": 1}}
+			},
+			{
+				"op": "d",
+				"ns": "test.teacher",
+				"o": {"_id": "2"}
+			}]`,
+			expectedSQL: []string{
+				"CREATE SCHEMA test;",
+				"CREATE TABLE test.student (_deleted BOOLEAN, _deleted_at TIMESTAMPTZ, _deleted_ts BIGINT, _id VARCHAR(255) PRIMARY KEY, name VARCHAR(255));",
+				"INSERT INTO test.student (_deleted, _deleted_at, _deleted_ts, _id, name) VALUES (false, NULL, NULL, '1', 'Selena') ON CONFLICT (_id) DO UPDATE SET _deleted = EXCLUDED._deleted, _deleted_at = EXCLUDED._deleted_at, _deleted_ts = EXCLUDED._deleted_ts, name = EXCLUDED.name;",
+				"UPDATE test.student SET _deleted_at = to_timestamp(1685687400), _deleted_ts = 7239972254279270401, _deleted = true WHERE _id = '1';",
+				"INSERT INTO test.student (_deleted, _deleted_at, _deleted_ts, _id, name) VALUES (false, NULL, NULL, '1', 'Selena') ON CONFLICT (_id) DO UPDATE SET _deleted = EXCLUDED._deleted, _deleted_at = EXCLUDED._deleted_at, _deleted_ts = EXCLUDED._deleted_ts, name = EXCLUDED.name;",
+				"DELETE FROM test.teacher WHERE _id = '2';",
+			},
+		},
+		{
+			name: "Soft delete: a re-insert upserts the child rows too",
+			config: Config{Namespaces: map[string]NamespaceConfig{
+				"test.student": {SoftDelete: true},
+			}},
+			inputJSON: `[{
+				"op": "i",
+				"ns": "test.student",
+				"o": {"_id": "1", "address": {"city": "Pune"}}
+			},
+			{
+				"op": "d",
+				"ns": "test.student",
+				"o": {"_id": "1"}
+			},
+			{
+				"op": "i",
+				"ns": "test.student",
+				"o": {"_id": "1", "address": {"city": "Pune"}}
+			}]`,
+			expectedSQL: []string{
+				"CREATE SCHEMA test;",
+				"CREATE TABLE test.student (_deleted BOOLEAN, _deleted_at TIMESTAMPTZ, _deleted_ts BIGINT, _id VARCHAR(255) PRIMARY KEY);",
+				"CREATE TABLE test.student_address (_id VARCHAR(255) PRIMARY KEY, city VARCHAR(255), student__id VARCHAR(255));",
+				"INSERT INTO test.student_address (_id, city, student__id) VALUES ('f839efb6-d3fc-5ac9-9af4-b89cd866ba6d', 'Pune', '1') ON CONFLICT (_id) DO UPDATE SET city = EXCLUDED.city, student__id = EXCLUDED.student__id;",
+				"INSERT INTO test.student (_deleted, _deleted_at, _deleted_ts, _id) VALUES (false, NULL, NULL, '1') ON CONFLICT (_id) DO UPDATE SET _deleted = EXCLUDED._deleted, _deleted_at = EXCLUDED._deleted_at, _deleted_ts = EXCLUDED._deleted_ts;",
+				"UPDATE test.student SET _deleted_at = now(), _deleted_ts = NULL, _deleted = true WHERE _id = '1';",
+				"INSERT INTO test.student_address (_id, city, student__id) VALUES ('f839efb6-d3fc-5ac9-9af4-b89cd866ba6d', 'Pune', '1') ON CONFLICT (_id) DO UPDATE SET city = EXCLUDED.city, student__id = EXCLUDED.student__id;",
+				"INSERT INTO test.student (_deleted, _deleted_at, _deleted_ts, _id) VALUES (false, NULL, NULL, '1') ON CONFLICT (_id) DO UPDATE SET _deleted = EXCLUDED._deleted, _deleted_at = EXCLUDED._deleted_at, _deleted_ts = EXCLUDED._deleted_ts;",
+			},
+		},
+		{
+			name: "Soft delete: markers are added to an existing table",
+			config: Config{
+				Namespaces: map[string]NamespaceConfig{"test.student": {SoftDelete: true}},
+			},
+			tracker: seededTracker("test.student", map[string]string{"_id": "VARCHAR(255)", "name": "VARCHAR(255)"}),
+			inputJSON: `[{
+				"op": "d",
+				"ns": "test.student",
+				"o": {"_id": "1"}
+			}]`,
+			expectedSQL: []string{
+				"ALTER TABLE test.student ADD _deleted BOOLEAN, _deleted_at TIMESTAMPTZ, _deleted_ts BIGINT;",
+				"UPDATE test.student SET _deleted_at = now(), _deleted_ts = NULL, _deleted = true WHERE _id = '1';",
+			},
+		},
+		{
+			name: "Update: embedded documents and arrays of documents replace their child rows",
+			inputJSON: `[{
+				"op": "i",
+				"ns": "test.student",
+				"o": {"_id": "1", "name": "Selena", "address": {"city": "Pune"}}
+			},
+			{
+				"op": "u",
+				"ns": "test.student",
+				"o": {"diff": {"u": {"name": "Sel", "address": {"city": "Goa"}, "exams": [{"score": 9}]}}},
+				"o2": {"_id": "1"}
+			}]`,
+			expectedSQL: []string{
+				"CREATE SCHEMA test;",
+				"CREATE TABLE test.student (_id VARCHAR(255) PRIMARY KEY, name VARCHAR(255));",
+				"CREATE TABLE test.student_address (_id VARCHAR(255) PRIMARY KEY, city VARCHAR(255), student__id VARCHAR(255));",
+				"INSERT INTO test.student_address (_id, city, student__id) VALUES ('random-uuid', 'Pune', '1');",
+				"INSERT INTO test.student (_id, name) VALUES ('1', 'Selena');",
+				"DELETE FROM test.student_address WHERE student__id = '1';",
+				"INSERT INTO test.student_address (_id, city, student__id) VALUES ('random-uuid', 'Goa', '1');",
+				"CREATE TABLE test.student_exams (_id VARCHAR(255) PRIMARY KEY, score FLOAT, student__id VARCHAR(255));",
+				"INSERT INTO test.student_exams (_id, score, student__id) VALUES ('random-uuid', 9, '1');",
+				"UPDATE test.student SET name = 'Sel' WHERE _id = '1';",
+			},
+		},
+		{
+			name: "Binary values as the readers deliver them are stored as BYTEA",
+			inputJSON: `[{
+				"op": "i",
+				"ns": "test.student",
+				"o": {
+					"_id": "1",
+					"photo": {"Subtype": 0, "Data": "3q2+7w=="},
+					"thumb": {"$binary": {"base64": "AQI=", "subType": "00"}},
+					"legacy": {"$binary": "AQI=", "$type": "00"}
+				}
+			},
+			{
+				"op": "u",
+				"ns": "test.student",
+				"o": {"diff": {"u": {"photo": {"Subtype": 0, "Data": "AQ=="}}}},
+				"o2": {"_id": "1"}
+			}]`,
+			expectedSQL: []string{
+				"CREATE SCHEMA test;",
+				"CREATE TABLE test.student (_id VARCHAR(255) PRIMARY KEY, legacy BYTEA, photo BYTEA, thumb BYTEA);",
+				`INSERT INTO test.student (_id, legacy, photo, thumb) VALUES ('1', E'\\x0102'::bytea, E'\\xdeadbeef'::bytea, E'\\x0102'::bytea);`,
+				`UPDATE test.student SET photo = E'\\x01'::bytea WHERE _id = '1';`,
+			},
+		},
+		{
+			name:   "History: operations are appended next to the current state",
+			config: Config{History: HistoryBoth},
+			inputJSON: `[{
+				"op": "i",
+				"ns": "test.student",
+				"o": {"_id": "1", "name": "Selena"},
+				"ts": {"T": 1685687329, "I": 2},
+				"wall": "2023-06-02T11:58:49.457+05:30",
+				"txnNumber": 1
+			},
+			{
+				"op": "u",
+				"ns": "test.student",
+				"o": {"diff": {"u": {"name": "Sel"}, "d": {"age": false}}},
+				"o2": {"_id": "1"},
+				"ts": {"T": 1685687330, "I": 1}
+			}]`,
+			expectedSQL: []string{
+				"CREATE SCHEMA test;",
+				"CREATE TABLE test.student (_id VARCHAR(255) PRIMARY KEY, name VARCHAR(255));",
+				"INSERT INTO test.student (_id, name) VALUES ('1', 'Selena');",
+				"CREATE TABLE test.student_history (_history_id BIGSERIAL PRIMARY KEY, _changes JSONB, _id VARCHAR(255), _op VARCHAR(10), _oplog_ts BIGINT, _txn_number BIGINT, _wall TIMESTAMPTZ);",
+				`INSERT INTO test.student_history (_changes, _id, _op, _oplog_ts, _txn_number, _wall) VALUES ('{"_id":"1","name":"Selena"}'::jsonb, '1', 'insert', 7239971949336592386, 1, '2023-06-02T11:58:49.457+05:30');`,
+				"UPDATE test.student SET name = 'Sel' WHERE _id = '1';",
+				`INSERT INTO test.student_history (_changes, _id, _op, _oplog_ts, _txn_number, _wall) VALUES ('{"set":{"name":"Sel"},"unset":["age"]}'::jsonb, '1', 'update', 7239971953631559681, NULL, NULL);`,
+			},
+		},
+		{
+			name:   "History: only history tables with a composite _id",
+			config: Config{History: HistoryOnly},
+			inputJSON: `[{
+				"op": "i",
+				"ns": "test.enrollment",
+				"o": {"_id": {"student": "s1", "year": 2024}, "grade": "A"}
+			},
+			{
+				"op": "d",
+				"ns": "test.enrollment",
+				"o": {"_id": {"student": "s1", "year": 2024}}
+			}]`,
+			expectedSQL: []string{
+				"CREATE SCHEMA test;",
+				"CREATE TABLE test.enrollment_history (_history_id BIGSERIAL PRIMARY KEY, _changes JSONB, _id_student VARCHAR(255), _id_year BIGINT, _op VARCHAR(10), _oplog_ts BIGINT, _txn_number BIGINT, _wall TIMESTAMPTZ);",
+				`INSERT INTO test.enrollment_history (_changes, _id_student, _id_year, _op, _oplog_ts, _txn_number, _wall) VALUES ('{"_id":{"student":"s1","year":2024},"grade":"A"}'::jsonb, 's1', 2024, 'insert', NULL, NULL, NULL);`,
+				"INSERT INTO test.enrollment_history (_changes, _id_student, _id_year, _op, _oplog_ts, _txn_number, _wall) VALUES (NULL, 's1', 2024, 'delete', NULL, NULL, NULL);",
+			},
+		},
+		{
+			name:   "Metadata columns: filled on insert, update and soft delete",
+			config: Config{MetadataColumns: true, Namespaces: map[string]NamespaceConfig{"test.student": {SoftDelete: true}}},
+			inputJSON: `[{
+				"lsid": {"id": {"Subtype": 4, "Data": "Pd3SzDbdTAe8wR86M1x0Pw=="}, "uid": {"Subtype": 0, "Data": "47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU="}},
+				"op": "i",
+				"ns": "test.student",
+				"o": {"_id": "1", "name": "Selena"},
+				"t": 1,
+				"ts": {"T": 1685687329, "I": 2},
+				"txnNumber": 1,
+				"ui": {"$binary": {"base64": "Vn3IRssuTqmZgBMWQ47Olg==", "subType": "04"}},
+				"wall": "2023-06-02T11:58:49.457+05:30"
+			},
+			{
+				"op": "u",
+				"ns": "test.student",
+				"o": {"diff": {"d": {"name": false}}},
+				"o2": {"_id": "1"},
+				"ts": {"T": 1685687330, "I": 1}
+			},
+			{
+				"op": "d",
+				"ns": "test.student",
+				"o": {"_id": "1"},
+				"ts": {"T": 1685687331, "I": 1}
+			}]`,
+			expectedSQL: []string{
+				"CREATE SCHEMA test;",
+				"CREATE TABLE test.student (_deleted BOOLEAN, _deleted_at TIMESTAMPTZ, _deleted_ts BIGINT, _id VARCHAR(255) PRIMARY KEY, _oplog_op VARCHAR(10), _oplog_ts BIGINT, _source_ns VARCHAR(255), _synced_at TIMESTAMPTZ, name VARCHAR(255));",
+				"INSERT INTO test.student (_deleted, _deleted_at, _deleted_ts, _id, _oplog_op, _oplog_ts, _source_ns, _synced_at, name) VALUES (false, NULL, NULL, '1', 'insert', 7239971949336592386, 'test.student', now(), 'Selena') ON CONFLICT (_id) DO UPDATE SET _deleted = EXCLUDED._deleted, _deleted_at = EXCLUDED._deleted_at, _deleted_ts = EXCLUDED._deleted_ts, _oplog_op = EXCLUDED._oplog_op, _oplog_ts = EXCLUDED._oplog_ts, _source_ns = EXCLUDED._source_ns, _synced_at = EXCLUDED._synced_at, name = EXCLUDED.name;",
+				"UPDATE test.student SET name = NULL, _oplog_op = 'update', _oplog_ts = 7239971953631559681, _source_ns = 'test.student', _synced_at = now() WHERE _id = '1';",
+				"UPDATE test.student SET _deleted_at = to_timestamp(1685687331), _deleted_ts = 7239971957926526977, _deleted = true, _oplog_op = 'delete', _oplog_ts = 7239971957926526977, _source_ns = 'test.student', _synced_at = now() WHERE _id = '1';",
+			},
+		},
+		{
+			name:    "Metadata columns: added to an existing table on update",
+			config:  Config{MetadataColumns: true},
+			tracker: seededTracker("test.student", map[string]string{"_id": "VARCHAR(255)", "name": "VARCHAR(255)"}),
+			inputJSON: `[{
+				"op": "u",
+				"ns": "test.student",
+				"o": {"diff": {"u": {"name": "Sel"}}},
+				"o2": {"_id": "1"}
+			}]`,
+			expectedSQL: []string{
+				"ALTER TABLE test.student ADD _oplog_op VARCHAR(10), _source_ns VARCHAR(255), _synced_at TIMESTAMPTZ;",
+				"UPDATE test.student SET name = 'Sel', _oplog_op = 'update', _source_ns = 'test.student', _synced_at = now() WHERE _id = '1';",
+			},
+		},
+		{
+			name:   "Timestamp guard: updates and deletes only apply to older rows",
+			config: Config{TimestampGuard: true, Upsert: true},
+			inputJSON: `[{
+				"op": "i",
+				"ns": "test.student",
+				"o": {"_id": "1", "name": "Selena"},
+				"ts": {"T": 1685687329, "I": 2}
+			},
+			{
+				"op": "u",
+				"ns": "test.student",
+				"o": {"diff": {"u": {"name": "Sel"}}},
+				"o2": {"_id": "1"},
+				"ts": {"T": 1685687330, "I": 1}
+			},
+			{
+				"op": "u",
+				"ns": "test.student",
+				"o": {"diff": {"u": {"name": "Unknown ts"}}},
+				"o2": {"_id": "1"}
+			},
+			{
+				"op": "d",
+				"ns": "test.student",
+				"o": {"_id": "1"},
+				"ts": {"T": 1685687331, "I": 1}
+			}]`,
+			expectedSQL: []string{
+				"CREATE SCHEMA IF NOT EXISTS test;",
+				"CREATE TABLE IF NOT EXISTS test.student (_id VARCHAR(255) PRIMARY KEY, _oplog_ts BIGINT, name VARCHAR(255));",
+				"INSERT INTO test.student (_id, _oplog_ts, name) VALUES ('1', 7239971949336592386, 'Selena') ON CONFLICT (_id) DO UPDATE SET _oplog_ts = EXCLUDED._oplog_ts, name = EXCLUDED.name WHERE test.student._oplog_ts IS NULL OR test.student._oplog_ts < EXCLUDED._oplog_ts;",
+				"UPDATE test.student SET name = 'Sel', _oplog_ts = 7239971953631559681 WHERE _id = '1' AND (_oplog_ts IS NULL OR _oplog_ts < 7239971953631559681);",
+				"UPDATE test.student SET name = 'Unknown ts' WHERE _id = '1';",
+				"DELETE FROM test.student WHERE _id = '1' AND (_oplog_ts IS NULL OR _oplog_ts < 7239971957926526977);",
+			},
+		},
+		{
+			name: "Child tables: fields first seen later are added with ALTER",
+			inputJSON: `[
+				{"op": "i", "ns": "test.student", "o": {"_id": "1", "phone": {"personal": "111"}}},
+				{"op": "i", "ns": "test.student", "o": {"_id": "2", "phone": {"personal": "222", "work": "333"}}}
+			]`,
+			expectedSQL: []string{
+				"CREATE SCHEMA test;",
+				"CREATE TABLE test.student (_id VARCHAR(255) PRIMARY KEY);",
+				"CREATE TABLE test.student_phone (_id VARCHAR(255) PRIMARY KEY, personal VARCHAR(255), student__id VARCHAR(255));",
+				"INSERT INTO test.student_phone (_id, personal, student__id) VALUES ('random-uuid', '111', '1');",
+				"INSERT INTO test.student (_id) VALUES ('1');",
+				"ALTER TABLE test.student_phone ADD work VARCHAR(255);",
+				"INSERT INTO test.student_phone (_id, personal, student__id, work) VALUES ('random-uuid', '222', '2', '333');",
+				"INSERT INTO test.student (_id) VALUES ('2');",
+			},
+		},
+		{
+			name:   "Array position: a column added to an existing child table gets its unique index",
+			config: Config{Upsert: true, ArrayPositionColumn: true},
+			tracker: func() *memory.SchemaTracker {
+				tracker := seededTracker("test.student", map[string]string{"_id": "VARCHAR(255)"})
+				tracker.MarkDDLGenerated("test.student_exams")
+				tracker.InitializeColumnTracker("test.student_exams", map[string]string{
+					"_id": "VARCHAR(255)", "student__id": "VARCHAR(255)", "score": "FLOAT",
+				})
+				return tracker
+			}(),
+			inputJSON: `[{"op": "i", "ns": "test.student", "o": {"_id": "1", "exams": [{"score": 9}]}}]`,
+			expectedSQL: []string{
+				"CREATE SCHEMA IF NOT EXISTS test;",
+				"ALTER TABLE test.student_exams ADD COLUMN IF NOT EXISTS _position INTEGER;",
+				"CREATE UNIQUE INDEX IF NOT EXISTS student_exams_student__id__position_key ON test.student_exams (student__id, _position);",
+				"INSERT INTO test.student_exams (_id, _position, score, student__id) VALUES ('9b20b5e3-f746-5bb6-9ab0-7f2a55f9c831', 0, 9, '1') ON CONFLICT (student__id, _position) DO UPDATE SET _id = EXCLUDED._id, score = EXCLUDED.score;",
+				"INSERT INTO test.student (_id) VALUES ('1') ON CONFLICT (_id) DO NOTHING;",
+			},
+		},
+		{
+			name:    "Column types: a value the introspected column type rejects fails the entry",
+			tracker: seededTracker("test.student", map[string]string{"_id": "VARCHAR(255)", "age": "INTEGER"}),
+			inputJSON: `[{
+				"op": "i",
+				"ns": "test.student",
+				"o": {"_id": "1", "age": "twenty"}
+			}]`,
+			expectedErr: errors.New("type conflict in test.student: age INTEGER: twenty"),
+		},
+		{
+			name:    "Column types: values the column type accepts are written",
+			tracker: seededTracker("test.student", map[string]string{"_id": "VARCHAR(255)", "age": "INTEGER", "active": "BOOLEAN", "note": "TEXT"}),
+			inputJSON: `[{
+				"op": "u",
+				"ns": "test.student",
+				"o": {"$v": 2, "diff": {"u": {"age": "42", "active": "yes", "note": 7}}},
+				"o2": {"_id": "1"}
+			}]`,
+			expectedSQL: []string{
+				"UPDATE test.student SET active = 'yes', age = '42', note = 7 WHERE _id = '1';",
+			},
+		},
+		{
+			name:    "Timestamp guard: an insert without ts keeps the ts of the row",
+			config:  Config{TimestampGuard: true, Upsert: true},
+			tracker: seededTracker("test.student", map[string]string{"_id": "VARCHAR(255)", "_oplog_ts": "BIGINT", "name": "VARCHAR(255)"}),
+			inputJSON: `[{
+				"op": "i",
+				"ns": "test.student",
+				"o": {"_id": "1", "name": "Selena"}
+			}]`,
+			expectedSQL: []string{
+				"CREATE SCHEMA IF NOT EXISTS test;",
+				"INSERT INTO test.student (_id, _oplog_ts, name) VALUES ('1', NULL, 'Selena') ON CONFLICT (_id) DO UPDATE SET name = EXCLUDED.name;",
+			},
+		},
+		{
+			name:    "Timestamp guard: the ts column is added to an existing table",
+			config:  Config{TimestampGuard: true},
+			tracker: seededTracker("test.student", map[string]string{"_id": "VARCHAR(255)"}),
+			inputJSON: `[{
+				"op": "d",
+				"ns": "test.student",
+				"o": {"_id": "1"},
+				"ts": {"T": 1685687331, "I": 1}
+			}]`,
+			expectedSQL: []string{
+				"ALTER TABLE test.student ADD _oplog_ts BIGINT;",
+				"DELETE FROM test.student WHERE _id = '1' AND (_oplog_ts IS NULL OR _oplog_ts < 7239971957926526977);",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tracker := tc.tracker
+			if tracker == nil {
+				tracker = memory.NewSchemaTracker()
+			}
+			parser := NewParserWithConfig(func() string { return uuid }, tracker, tc.config)
+			actualSQL, err := parser.Parse(tc.inputJSON)
+
+			if tc.expectedErr != nil {
+				if err == nil || err.Error() != tc.expectedErr.Error() {
+					t.Errorf("Expected error '%v', but got '%v'", tc.expectedErr, err)
+				}
+			} else if err != nil {
+				t.Errorf("Did not expect an error, but got: %v", err)
+			}
+
+			if !reflect.DeepEqual(actualSQL, tc.expectedSQL) {
+				t.Errorf("SQL mismatch:\nExpected: %s\nActual  : %s", tc.expectedSQL, actualSQL)
+			}
+		})
+	}
+}
+
+func TestProcessOpLogGuards(t *testing.T) {
+	parser := NewParserWithConfig(func() string { return uuid }, memory.NewSchemaTracker(), Config{TimestampGuard: true, Upsert: true})
+	testCases := []struct {
+		name           string
+		opLog          models.OpLog
+		expectedGuards []string
+	}{
+		{
+			name:  "insert",
+			opLog: models.OpLog{Operation: "i", Namespace: "test.student", Data: map[string]any{"_id": "1", "name": "Selena"}, Timestamp: models.Timestamp{T: 1685687329, I: 1}},
+			expectedGuards: []string{
+				"",
+				"",
+				"SELECT 1 FROM test.student WHERE _id = '1';",
+			},
+		},
+		{
+			name:           "update",
+			opLog:          models.OpLog{Operation: "u", Namespace: "test.student", Data: map[string]any{"diff": map[string]any{"u": map[string]any{"name": "Sel"}}}, O2: &models.O2Field{ID: "1"}, Timestamp: models.Timestamp{T: 1685687330, I: 1}},
+			expectedGuards: []string{"SELECT 1 FROM test.student WHERE _id = '1';"},
+		},
+		{
+			name:  "update without ts",
+			opLog: models.OpLog{Operation: "u", Namespace: "test.student", Data: map[string]any{"diff": map[string]any{"u": map[string]any{"name": "Sel"}}}, O2: &models.O2Field{ID: "1"}},
+		},
+		{
+			name:           "delete",
+			opLog:          models.OpLog{Operation: "d", Namespace: "test.student", Data: map[string]any{"_id": "1"}, Timestamp: models.Timestamp{T: 1685687331, I: 1}},
+			expectedGuards: []string{"SELECT 1 FROM test.student WHERE _id = '1';"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			statements, guards, err := parser.ProcessOpLog(tc.opLog)
+			if err != nil {
+				t.Fatalf("Did not expect an error, but got: %v", err)
+			}
+			if len(guards) != 0 && len(guards) != len(statements) {
+				t.Fatalf("Expected a guard per statement, got %d for %d statements", len(guards), len(statements))
+			}
+			if !reflect.DeepEqual(guards, tc.expectedGuards) {
+				t.Errorf("Guards mismatch:\nExpected: %q\nActual  : %q", tc.expectedGuards, guards)
+			}
+		})
+	}
+}
+
+func seededTracker(namespace string, columns map[string]string) *memory.SchemaTracker {
+	tracker := memory.NewSchemaTracker()
+	tracker.MarkDDLGenerated(namespace)
+	tracker.InitializeColumnTracker(namespace, columns)
+	return tracker
+}
+
+func TestParseWithPersistentSchemaTracker(t *testing.T) {
+	statePath := filepath.Join(t.TempDir(), "schema-state.json")
+	runs := []struct {
+		inputJSON   string
+		expectedSQL []string
+	}{
+		{
+			inputJSON: `[{"op": "i", "ns": "test.student", "o": {"_id": "1", "name": "Selena Miller"}}]`,
+			expectedSQL: []string{
+				"CREATE SCHEMA test;",
+				"CREATE TABLE test.student (_id VARCHAR(255) PRIMARY KEY, name VARCHAR(255));",
+				"INSERT INTO test.student (_id, name) VALUES ('1', 'Selena Miller');",
+			},
+		},
+		{
+			inputJSON: `[{"op": "i", "ns": "test.student", "o": {"_id": "2", "name": "Ramesh", "age": 24}},
+				{"op": "i", "ns": "test.teacher", "o": {"_id": "3", "name": "Superman"}}]`,
+			expectedSQL: []string{
+				"ALTER TABLE test.student ADD age FLOAT;",
+				"INSERT INTO test.student (_id, age, name) VALUES ('2', 24, 'Ramesh');",
+				"CREATE TABLE test.teacher (_id VARCHAR(255) PRIMARY KEY, name VARCHAR(255));",
+				"INSERT INTO test.teacher (_id, name) VALUES ('3', 'Superman');",
+			},
+		},
+	}
+
+	for i, run := range runs {
+		tracker, err := file.NewSchemaTracker(statePath)
+		if err != nil {
+			t.Fatalf("run %d: creating tracker: %v", i, err)
+		}
+		parser := NewParserWithConfig(func() string { return uuid }, tracker, Config{})
+		actualSQL, err := parser.Parse(run.inputJSON)
+		if err != nil {
+			t.Fatalf("run %d: did not expect an error, but got: %v", i, err)
+		}
+		if !reflect.DeepEqual(actualSQL, run.expectedSQL) {
+			t.Errorf("run %d: SQL mismatch:\nExpected: %s\nActual  : %s", i, run.expectedSQL, actualSQL)
+		}
+		tracker.Close()
+	}
+}
+
+func TestParseKeepsSchemaStateOfFailedEntries(t *testing.T) {
+	parser := NewParser(func() string { return uuid }, memory.NewSchemaTracker())
+
+	// The nested document needs an _id for its child table, the entry fails
+	// after the schema and table DDL were built
+	_, err := parser.Parse(`[{"op": "i", "ns": "a.b", "o": {"name": "x", "address": {"city": "y"}}}]`)
+	if err == nil {
+		t.Fatalf("Expected an error for the nested document without _id")
+	}
+
+	actualSQL, err := parser.Parse(`[{"op": "i", "ns": "a.b", "o": {"_id": "1", "name": "x"}}]`)
+	if err != nil {
+		t.Fatalf("Did not expect an error, but got: %v", err)
+	}
+	expectedSQL := []string{
+		"CREATE SCHEMA a;",
+		"CREATE TABLE a.b (_id VARCHAR(255) PRIMARY KEY, name VARCHAR(255));",
+		"INSERT INTO a.b (_id, name) VALUES ('1', 'x');",
+	}
+	if !reflect.DeepEqual(actualSQL, expectedSQL) {
+		t.Errorf("SQL mismatch:\nExpected: %s\nActual  : %s", expectedSQL, actualSQL)
+	}
+}
+
+func TestProcessOpLogRecordsSchemaOnceApplied(t *testing.T) {
+	tracker := memory.NewSchemaTracker()
+	parser := NewParser(func() string { return uuid }, tracker)
+	insert := func(id string) []string {
+		t.Helper()
+		statements, _, err := parser.ProcessOpLog(models.OpLog{Operation: Insert, Namespace: "a.b", Data: map[string]any{"_id": id}})
+		if err != nil {
+			t.Fatalf("Did not expect an error, but got: %v", err)
+		}
+		return statements
+	}
+	ddl := []string{
+		"CREATE SCHEMA a;",
+		"CREATE TABLE a.b (_id VARCHAR(255) PRIMARY KEY);",
+		"INSERT INTO a.b (_id) VALUES ('1');",
+	}
+
+	// A discarded entry leaves no schema state behind
+	insert("1")
+	parser.DiscardSchema()
+	if actual := insert("1"); !reflect.DeepEqual(actual, ddl) {
+		t.Errorf("SQL mismatch after discard:\nExpected: %s\nActual  : %s", ddl, actual)
+	}
+
+	// Staged changes are seen by later entries before they are recorded
+	commit := parser.StageSchema()
+	if tracker.IsDDLGenerated("a.b") {
+		t.Errorf("Expected the table not to be recorded before its statements were applied")
+	}
+	expected := []string{"INSERT INTO a.b (_id) VALUES ('2');"}
+	if actual := insert("2"); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("SQL mismatch after stage:\nExpected: %s\nActual  : %s", expected, actual)
+	}
+	parser.DiscardSchema()
+	if err := commit(); err != nil {
+		t.Fatalf("Did not expect an error, but got: %v", err)
+	}
+	if !tracker.IsDDLGenerated("a") || !tracker.IsDDLGenerated("a.b") {
+		t.Errorf("Expected the schema and table to be recorded once applied")
+	}
+}
+
+func TestUpsertReplaysChildRowsOntoTheSameRow(t *testing.T) {
+	input := `[{"op": "i", "ns": "test.student", "o": {"_id": "1", "address": {"city": "Pune"}}}]`
+	var runs [][]string
+	for range 2 {
+		parser := NewParserWithConfig(func() string { return googleuuid.NewString() }, memory.NewSchemaTracker(), Config{Upsert: true})
+		statements, err := parser.Parse(input)
+		if err != nil {
+			t.Fatalf("Did not expect an error, but got: %v", err)
+		}
+		runs = append(runs, statements)
+	}
+	if !reflect.DeepEqual(runs[0], runs[1]) {
+		t.Errorf("Expected a replay to produce the same statements:\nFirst : %s\nSecond: %s", runs[0], runs[1])
+	}
+
+	config := Config{Upsert: true, ChildIDStrategy: ChildIDRandom}
+	if err := config.Validate(); err == nil {
+		t.Errorf("Expected upsert with random child ids to be rejected")
+	}
+	config = Config{ChildIDStrategy: ChildIDRandom, Namespaces: map[string]NamespaceConfig{"test.student": {SoftDelete: true}}}
+	if err := config.Validate(); err == nil {
+		t.Errorf("Expected soft delete with random child ids to be rejected")
+	}
+}
+
+func TestBinaryValue(t *testing.T) {
+	binary := primitive.Binary{Subtype: 0, Data: []byte{0xde, 0xad, 0xbe, 0xef}}
+	encode := map[string]func() ([]byte, error){
+		"Go driver JSON":          func() ([]byte, error) { return json.Marshal(bson.M{"v": binary}) },
+		"canonical extended JSON": func() ([]byte, error) { return bson.MarshalExtJSON(bson.M{"v": binary}, true, false) },
+		"relaxed extended JSON":   func() ([]byte, error) { return bson.MarshalExtJSON(bson.M{"v": binary}, false, false) },
+	}
+	for name, encode := range encode {
+		t.Run(name, func(t *testing.T) {
+			encoded, err := encode()
+			if err != nil {
+				t.Fatalf("Did not expect an error, but got: %v", err)
+			}
+			var doc map[string]any
+			if err := json.Unmarshal(encoded, &doc); err != nil {
+				t.Fatalf("Did not expect an error, but got: %v", err)
+			}
+			actual, ok := binaryValue(doc["v"])
+			if !ok || !bytes.Equal(actual, binary.Data) {
+				t.Errorf("Expected %x from %s, got %x (%v)", binary.Data, encoded, actual, ok)
+			}
+		})
+	}
+
+	for _, value := range []any{"3q2+7w==", map[string]any{"Data": "x"}, map[string]any{"Subtype": "0", "Data": "3q2+7w=="}, map[string]any{"$binary": "not base64!", "$type": "00"}} {
+		if _, ok := binaryValue(value); ok {
+			t.Errorf("Did not expect %v to be read as binary", value)
+		}
+	}
+}
+
+func TestFormatValue(t *testing.T) {
+	testCases := []struct {
+		name        string
+		value       any
+		expected    string
+		expectedErr bool
+	}{
+		{name: "null", value: nil, expected: "NULL"},
+		{name: "quotes are doubled", value: "O'Malley", expected: "'O''Malley'"},
+		{name: "backslashes use escape strings", value: `C:\temp\it's`, expected: `E'C:\\temp\\it''s'`},
+		{name: "integral float", value: 23.0, expected: "23"},
+		{name: "full float precision", value: 3767.92563512345, expected: "3767.92563512345"},
+		{name: "large float is not cast through int", value: 1e20, expected: "100000000000000000000"},
+		{name: "NaN", value: math.NaN(), expected: "'NaN'::float8"},
+		{name: "Infinity", value: math.Inf(1), expected: "'Infinity'::float8"},
+		{name: "negative Infinity", value: math.Inf(-1), expected: "'-Infinity'::float8"},
+		{name: "bytes as bytea hex", value: []byte{0xde, 0xad, 0xbe, 0xef}, expected: `E'\\xdeadbeef'::bytea`},
+		{name: "JSONB", value: jsonValue{value: []any{"it's", 1.5}}, expected: `'["it''s",1.5]'::jsonb`},
+		{name: "maps are refused", value: map[string]any{"a": 1}, expectedErr: true},
+		{name: "slices are refused", value: []any{1}, expectedErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			actual, err := formatValue(tc.value)
+			if tc.expectedErr {
+				if err == nil {
+					t.Errorf("Expected error, but got %s", actual)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("Did not expect an error, but got: %v", err)
+			}
+			if actual != tc.expected {
+				t.Errorf("Literal mismatch:\nExpected: %s\nActual  : %s", tc.expected, actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,224 @@
+package parsers
+
+import (
+	"maps"
+	"op-log-parser/application/domain/services"
+	"slices"
+	"sync"
+)
+
+// stagedTracker collects the schema changes made while oplog entries are
+// processed. Reads see them on top of the wrapped tracker, which only
+// receives them once the statements they were made for are applied: the
+// changes of the current unit of entries are staged as a whole, or
+// discarded when the unit fails or is dropped, and staged units are
+// committed in order. A process stopping between parse and apply therefore
+// leaves no DDL marked that never reached the target.
+type stagedTracker struct {
+	// mu guards the stages and the wrapped tracker, which are committed by
+	// the writer while the parser reads them.
+	mu      sync.Mutex
+	base    services.SchemaTracker
+	pending []*stage
+	current *stage
+}
+
+// stage holds the schema changes of one unit of entries.
+type stage struct {
+	namespaces map[string]*stagedNamespace
+	changes    []func(services.SchemaTracker) error
+}
+
+// stagedNamespace is the overlay of one namespace. reset is set once the
+// columns were initialized, hiding the columns of the layers below.
+type stagedNamespace struct {
+	ddlGenerated bool
+	reset        bool
+	columns      map[string]string
+	names        map[string]string
+}
+
+var _ services.SchemaTracker = (*stagedTracker)(nil)
+
+func newStagedTracker(base services.SchemaTracker) *stagedTracker {
+	return &stagedTracker{
+		base:    base,
+		current: newStage(),
+	}
+}
+
+func newStage() *stage {
+	return &stage{namespaces: make(map[string]*stagedNamespace)}
+}
+
+// stage ends the current unit. Its changes stay visible and are applied to
+// the wrapped tracker by the returned function, along with those of the
+// units staged before it.
+func (t *stagedTracker) stage() func() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	staged := t.current
+	t.current = newStage()
+	t.pending = append(t.pending, staged)
+	return func() error {
+		return t.commit(staged)
+	}
+}
+
+// commit applies the changes of the pending units up to and including
+// staged to the wrapped tracker in order.
+func (t *stagedTracker) commit(staged *stage) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	end := slices.Index(t.pending, staged)
+	if end < 0 {
+		return nil
+	}
+	for i, unit := range t.pending[:end+1] {
+		for _, change := range unit.changes {
+			if err := change(t.base); err != nil {
+				t.pending = t.pending[i:]
+				return err
+			}
+		}
+	}
+	t.pending = t.pending[end+1:]
+	return nil
+}
+
+// discard drops the changes of the current unit.
+func (t *stagedTracker) discard() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.current = newStage()
+}
+
+// layers returns the overlays of namespace, oldest first.
+func (t *stagedTracker) layers(namespace string) []*stagedNamespace {
+	var layers []*stagedNamespace
+	for _, unit := range append(slices.Clip(t.pending), t.current) {
+		if state, ok := unit.namespaces[namespace]; ok {
+			layers = append(layers, state)
+		}
+	}
+	return layers
+}
+
+func (t *stagedTracker) IsDDLGenerated(namespace string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, state := range t.layers(namespace) {
+		if state.ddlGenerated {
+			return true
+		}
+	}
+	return t.base.IsDDLGenerated(namespace)
+}
+
+func (t *stagedTracker) MarkDDLGenerated(namespace string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.namespace(namespace).ddlGenerated = true
+	t.current.changes = append(t.current.changes, func(base services.SchemaTracker) error {
+		return base.MarkDDLGenerated(namespace)
+	})
+	return nil
+}
+
+func (t *stagedTracker) GetKnownColumns(namespace string) map[string]bool {
+	columns := make(map[string]bool)
+	for col := range t.GetColumnTypes(namespace) {
+		columns[col] = true
+	}
+	return columns
+}
+
+func (t *stagedTracker) GetColumnTypes(namespace string) map[string]string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	columns := t.base.GetColumnTypes(namespace)
+	for _, state := range t.layers(namespace) {
+		if state.reset {
+			columns = make(map[string]string)
+		}
+		maps.Copy(columns, state.columns)
+	}
+	return columns
+}
+
+func (t *stagedTracker) InitializeColumnTracker(namespace string, columns map[string]string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	state := t.namespace(namespace)
+	state.reset = true
+	state.columns = make(map[string]string, len(columns))
+	maps.Copy(state.columns, columns)
+	columns = maps.Clone(columns)
+	t.current.changes = append(t.current.changes, func(base services.SchemaTracker) error {
+		return base.InitializeColumnTracker(namespace, columns)
+	})
+	return nil
+}
+
+func (t *stagedTracker) UpdateColumnsTracker(namespace string, newColumns map[string]string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	state := t.namespace(namespace)
+	for col, sqlType := range newColumns {
+		state.columns[col] = sqlType
+	}
+	newColumns = maps.Clone(newColumns)
+	t.current.changes = append(t.current.changes, func(base services.SchemaTracker) error {
+		return base.UpdateColumnsTracker(namespace, newColumns)
+	})
+	return nil
+}
+
+func (t *stagedTracker) MarkColumnsNullable(namespace string, columns []string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	columns = slices.Clone(columns)
+	t.current.changes = append(t.current.changes, func(base services.SchemaTracker) error {
+		return base.MarkColumnsNullable(namespace, columns)
+	})
+	return nil
+}
+
+func (t *stagedTracker) GetColumnNames(namespace string) map[string]string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	names := t.base.GetColumnNames(namespace)
+	for _, state := range t.layers(namespace) {
+		maps.Copy(names, state.names)
+	}
+	return names
+}
+
+func (t *stagedTracker) SetColumnNames(namespace string, names map[string]string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	state := t.namespace(namespace)
+	maps.Copy(state.names, names)
+	names = maps.Clone(names)
+	t.current.changes = append(t.current.changes, func(base services.SchemaTracker) error {
+		return base.SetColumnNames(namespace, names)
+	})
+	return nil
+}
+
+func (t *stagedTracker) Close() error {
+	return t.base.Close()
+}
+
+// namespace returns the overlay of namespace in the current unit.
+func (t *stagedTracker) namespace(namespace string) *stagedNamespace {
+	state, ok := t.current.namespaces[namespace]
+	if !ok {
+		state = &stagedNamespace{
+			columns: make(map[string]string),
+			names:   make(map[string]string),
+		}
+		t.current.namespaces[namespace] = state
+	}
+	return state
+}
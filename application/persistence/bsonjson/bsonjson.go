@@ -0,0 +1,60 @@
+// Package bsonjson encodes decoded BSON values as the JSON the processor
+// reads.
+package bsonjson
+
+import (
+	"encoding/json"
+	"math"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Marshal returns the JSON encoding of value. JSON has no NaN or infinite
+// numbers, they are encoded as the strings "NaN", "Infinity" and
+// "-Infinity", which PostgreSQL accepts as floating point input.
+func Marshal(value any) ([]byte, error) {
+	return json.Marshal(finiteFloats(value))
+}
+
+// finiteFloats returns value with its NaN and infinite floats, at any depth,
+// replaced by their string form.
+func finiteFloats(value any) any {
+	switch val := value.(type) {
+	case float64:
+		switch {
+		case math.IsNaN(val):
+			return "NaN"
+		case math.IsInf(val, 1):
+			return "Infinity"
+		case math.IsInf(val, -1):
+			return "-Infinity"
+		}
+		return val
+	case float32:
+		return finiteFloats(float64(val))
+	case bson.M:
+		converted := make(bson.M, len(val))
+		for key, item := range val {
+			converted[key] = finiteFloats(item)
+		}
+		return converted
+	case map[string]any:
+		return finiteFloats(bson.M(val))
+	case bson.D:
+		converted := make(bson.D, len(val))
+		for i, elem := range val {
+			converted[i] = bson.E{Key: elem.Key, Value: finiteFloats(elem.Value)}
+		}
+		return converted
+	case bson.A:
+		converted := make(bson.A, len(val))
+		for i, item := range val {
+			converted[i] = finiteFloats(item)
+		}
+		return converted
+	case []any:
+		return []any(finiteFloats(bson.A(val)).(bson.A))
+	default:
+		return value
+	}
+}
@@ -0,0 +1,38 @@
+package file
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "state.json")
+
+	for _, content := range []string{`{"v":1}`, `{"v":2}`} {
+		if err := writeFileAtomic(path, []byte(content)); err != nil {
+			t.Fatalf("Did not expect an error, but got: %v", err)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("Did not expect an error, but got: %v", err)
+		}
+		if string(data) != content {
+			t.Errorf("Expected %q, got %q", content, data)
+		}
+	}
+
+	// The temporary files are renamed over the target, none is left behind
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("Did not expect an error, but got: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("Expected only the target file, got %d entries", len(entries))
+	}
+
+	if err := writeFileAtomic(filepath.Join(dir, "missing", "state.json"), []byte("{}")); err == nil {
+		t.Errorf("Expected an error writing into a missing directory")
+	}
+}
@@ -0,0 +1,58 @@
+package file
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"op-log-parser/application/persistence/bsonjson"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// maxBSONDocumentSize bounds the length prefix of a document. Oplog entries
+// can exceed the 16MB document limit slightly, never by this much.
+const maxBSONDocumentSize = 64 * 1024 * 1024
+
+// bsonDecoder streams the length-prefixed documents of a BSON file, as
+// written by mongodump, converted to the JSON form of the Mongo reader.
+type bsonDecoder struct {
+	reader *bufio.Reader
+}
+
+func newBSONDecoder(r io.Reader) *bsonDecoder {
+	return &bsonDecoder{reader: bufio.NewReader(r)}
+}
+
+// Next returns the next entry, or io.EOF once the input is exhausted.
+func (d *bsonDecoder) Next() (json.RawMessage, error) {
+	var prefix [4]byte
+	if _, err := io.ReadFull(d.reader, prefix[:]); err != nil {
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		return nil, fmt.Errorf("reading BSON document length: %v", err)
+	}
+	length := binary.LittleEndian.Uint32(prefix[:])
+	if length < 5 || length > maxBSONDocumentSize {
+		return nil, fmt.Errorf("invalid BSON document length: %d", length)
+	}
+
+	document := make([]byte, length)
+	copy(document, prefix[:])
+	if _, err := io.ReadFull(d.reader, document[4:]); err != nil {
+		return nil, fmt.Errorf("reading BSON document: %v", err)
+	}
+
+	var raw bson.M
+	if err := bson.Unmarshal(document, &raw); err != nil {
+		return nil, fmt.Errorf("decoding BSON document: %v", err)
+	}
+	entry, err := bsonjson.Marshal(raw)
+	if err != nil {
+		return nil, fmt.Errorf("encoding oplog entry: %v", err)
+	}
+	return entry, nil
+}
@@ -0,0 +1,149 @@
+package file
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func bsonDocument(t *testing.T, doc any) []byte {
+	t.Helper()
+	encoded, err := bson.Marshal(doc)
+	if err != nil {
+		t.Fatalf("Did not expect an error, but got: %v", err)
+	}
+	return encoded
+}
+
+// lengthPrefix returns a document length prefix of n.
+func lengthPrefix(n uint32) []byte {
+	prefix := make([]byte, 4)
+	binary.LittleEndian.PutUint32(prefix, n)
+	return prefix
+}
+
+func TestBSONDecoder(t *testing.T) {
+	insert := bsonDocument(t, bson.D{
+		{Key: "op", Value: "i"},
+		{Key: "ns", Value: "test.student"},
+		{Key: "ts", Value: primitive.Timestamp{T: 1685687329, I: 2}},
+		{Key: "o", Value: bson.D{{Key: "_id", Value: "1"}}},
+	})
+	del := bsonDocument(t, bson.D{{Key: "op", Value: "d"}, {Key: "o", Value: bson.D{{Key: "_id", Value: "1"}}}})
+	testCases := []struct {
+		name        string
+		input       []byte
+		expected    []string
+		expectedErr string
+	}{
+		{
+			name:  "documents are converted to JSON entries",
+			input: append(append([]byte{}, insert...), del...),
+			expected: []string{
+				`{"ns":"test.student","o":{"_id":"1"},"op":"i","ts":{"T":1685687329,"I":2}}`,
+				`{"o":{"_id":"1"},"op":"d"}`,
+			},
+		},
+		{
+			name: "non-finite floats are encoded as strings",
+			input: bsonDocument(t, bson.D{{Key: "op", Value: "i"}, {Key: "o", Value: bson.D{
+				{Key: "_id", Value: "1"},
+				{Key: "scores", Value: bson.A{math.NaN(), math.Inf(1), math.Inf(-1), 1.5}},
+			}}}),
+			expected: []string{`{"o":{"_id":"1","scores":["NaN","Infinity","-Infinity",1.5]},"op":"i"}`},
+		},
+		{
+			name:     "empty input",
+			input:    nil,
+			expected: nil,
+		},
+		{
+			name:        "length prefix below the minimum",
+			input:       lengthPrefix(4),
+			expectedErr: "invalid BSON document length: 4",
+		},
+		{
+			name:        "length prefix above the maximum",
+			input:       lengthPrefix(maxBSONDocumentSize + 1),
+			expectedErr: "invalid BSON document length",
+		},
+		{
+			name:        "truncated length prefix",
+			input:       append(append([]byte{}, insert...), 0x20, 0x00),
+			expected:    []string{`{"ns":"test.student","o":{"_id":"1"},"op":"i","ts":{"T":1685687329,"I":2}}`},
+			expectedErr: "reading BSON document length",
+		},
+		{
+			name:        "truncated document",
+			input:       del[:len(del)-3],
+			expectedErr: "reading BSON document",
+		},
+		{
+			name:        "malformed document",
+			input:       append(lengthPrefix(8), 0x55, 0x61, 0x00, 0x00),
+			expectedErr: "decoding BSON document",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dec := newBSONDecoder(bytes.NewReader(tc.input))
+			var actual []string
+			var err error
+			for {
+				var entry []byte
+				entry, err = dec.Next()
+				if err != nil {
+					break
+				}
+				actual = append(actual, string(entry))
+			}
+			if tc.expectedErr == "" && err != io.EOF {
+				t.Errorf("Did not expect an error, but got: %v", err)
+			}
+			if tc.expectedErr != "" && (err == io.EOF || !strings.Contains(err.Error(), tc.expectedErr)) {
+				t.Errorf("Expected an error containing %q, got %v", tc.expectedErr, err)
+			}
+			if !reflect.DeepEqual(actual, tc.expected) {
+				t.Errorf("Entries mismatch:\nExpected: %q\nActual  : %q", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestOpenInputDoesNotSniffBSON(t *testing.T) {
+	// A document of 0x8b1f bytes starts with the gzip magic number
+	doc := bsonDocument(t, bson.D{{Key: "s", Value: strings.Repeat("x", 0x8b1f-13)}})
+	if !bytes.HasPrefix(doc, gzipMagic) {
+		t.Fatalf("Expected the document to start with the gzip magic number, got % x", doc[:4])
+	}
+	path := filepath.Join(t.TempDir(), "oplog.bson")
+	if err := os.WriteFile(path, doc, 0o644); err != nil {
+		t.Fatalf("Did not expect an error, but got: %v", err)
+	}
+
+	in, err := openInput(path)
+	if err != nil {
+		t.Fatalf("Did not expect an error, but got: %v", err)
+	}
+	defer in.Close()
+	if !in.bson {
+		t.Errorf("Expected the input to be read as BSON")
+	}
+	actual, err := io.ReadAll(in)
+	if err != nil {
+		t.Fatalf("Did not expect an error, but got: %v", err)
+	}
+	if !bytes.Equal(actual, doc) {
+		t.Errorf("Expected the BSON document to be read as it is")
+	}
+}
@@ -0,0 +1,52 @@
+package file
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"op-log-parser/application/ports"
+)
+
+// checkpointStore keeps the checkpoint in a JSON file, rewritten atomically
+// on every save.
+type checkpointStore struct {
+	path string
+}
+
+func NewCheckpointStore(path string) ports.CheckpointStore {
+	return &checkpointStore{path: path}
+}
+
+func (s *checkpointStore) Load(ctx context.Context) (*ports.Checkpoint, error) {
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading checkpoint: %v", err)
+	}
+	var checkpoint ports.Checkpoint
+	if err := json.Unmarshal(data, &checkpoint); err != nil {
+		return nil, fmt.Errorf("parsing checkpoint %s: %v", s.path, err)
+	}
+	return &checkpoint, nil
+}
+
+func (s *checkpointStore) Save(ctx context.Context, checkpoint ports.Checkpoint) error {
+	data, err := json.Marshal(checkpoint)
+	if err != nil {
+		return fmt.Errorf("encoding checkpoint: %v", err)
+	}
+
+	if err := writeFileAtomic(s.path, data); err != nil {
+		return fmt.Errorf("writing checkpoint: %v", err)
+	}
+	return nil
+}
+
+func (s *checkpointStore) Close() error {
+	return nil
+}
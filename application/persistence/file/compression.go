@@ -0,0 +1,98 @@
+package file
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// input is an opened input file, decompressed on the fly when it is gzip or
+// zstd compressed.
+type input struct {
+	io.Reader
+	name string
+	// bson is set for BSON dumps, recognised by their .bson extension under
+	// the compression extension if any.
+	bson    bool
+	closers []io.Closer
+}
+
+func (in *input) Close() error {
+	var firstErr error
+	for i := len(in.closers) - 1; i >= 0; i-- {
+		if err := in.closers[i].Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// openInput opens path and detects compression from the extension, falling
+// back to the magic bytes at the start of the file. Uncompressed BSON dumps
+// are not sniffed: their length prefix can start like a magic number.
+func openInput(path string) (*input, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	in := &input{name: path, closers: []io.Closer{file}}
+	reader := bufio.NewReader(file)
+
+	in.bson = isBSONPath(path)
+	ext := strings.ToLower(filepath.Ext(path))
+	var magic []byte
+	if !in.bson || ext != ".bson" {
+		magic, _ = reader.Peek(len(zstdMagic))
+	}
+
+	switch {
+	case ext == ".gz" || ext == ".gzip" || bytes.HasPrefix(magic, gzipMagic):
+		decompressor, err := gzip.NewReader(reader)
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("opening gzip input %s: %v", path, err)
+		}
+		in.Reader = decompressor
+		in.closers = append(in.closers, decompressor)
+	case ext == ".zst" || ext == ".zstd" || bytes.HasPrefix(magic, zstdMagic):
+		decompressor, err := zstd.NewReader(reader)
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("opening zstd input %s: %v", path, err)
+		}
+		in.Reader = decompressor
+		in.closers = append(in.closers, decompressor.IOReadCloser())
+	default:
+		in.Reader = reader
+	}
+	return in, nil
+}
+
+// isBSONPath reports whether path names a BSON dump, possibly compressed, e.g.
+// oplog.bson or oplog.bson.gz.
+func isBSONPath(path string) bool {
+	return filepath.Ext(uncompressedName(path)) == ".bson"
+}
+
+// uncompressedName returns the lower-cased base name of path without its
+// compression extension, if any.
+func uncompressedName(path string) string {
+	name := strings.ToLower(filepath.Base(path))
+	switch filepath.Ext(name) {
+	case ".gz", ".gzip", ".zst", ".zstd":
+		name = strings.TrimSuffix(name, filepath.Ext(name))
+	}
+	return name
+}
@@ -0,0 +1,103 @@
+package file
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+)
+
+const compressionSample = `[{"op": "i", "ns": "test.student", "o": {"_id": "1"}}]`
+
+func gzipCompress(t *testing.T, data string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	if _, err := writer.Write([]byte(data)); err != nil {
+		t.Fatalf("Did not expect an error, but got: %v", err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Did not expect an error, but got: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func zstdCompress(t *testing.T, data string) []byte {
+	t.Helper()
+	encoder, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatalf("Did not expect an error, but got: %v", err)
+	}
+	defer encoder.Close()
+	return encoder.EncodeAll([]byte(data), nil)
+}
+
+func TestOpenInput(t *testing.T) {
+	testCases := []struct {
+		name    string
+		file    string
+		content func(t *testing.T) []byte
+	}{
+		{name: "plain", file: "oplog.json", content: func(t *testing.T) []byte { return []byte(compressionSample) }},
+		{name: "gzip by extension", file: "oplog.json.gz", content: func(t *testing.T) []byte { return gzipCompress(t, compressionSample) }},
+		{name: "gzip by .gzip extension", file: "oplog.json.gzip", content: func(t *testing.T) []byte { return gzipCompress(t, compressionSample) }},
+		{name: "gzip by magic bytes", file: "oplog.json", content: func(t *testing.T) []byte { return gzipCompress(t, compressionSample) }},
+		{name: "zstd by extension", file: "oplog.json.zst", content: func(t *testing.T) []byte { return zstdCompress(t, compressionSample) }},
+		{name: "zstd by .zstd extension", file: "oplog.json.zstd", content: func(t *testing.T) []byte { return zstdCompress(t, compressionSample) }},
+		{name: "zstd by magic bytes", file: "oplog", content: func(t *testing.T) []byte { return zstdCompress(t, compressionSample) }},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tc.file)
+			if err := os.WriteFile(path, tc.content(t), 0o644); err != nil {
+				t.Fatalf("Did not expect an error, but got: %v", err)
+			}
+			in, err := openInput(path)
+			if err != nil {
+				t.Fatalf("Did not expect an error, but got: %v", err)
+			}
+			defer in.Close()
+			actual, err := io.ReadAll(in)
+			if err != nil {
+				t.Fatalf("Did not expect an error, but got: %v", err)
+			}
+			if string(actual) != compressionSample {
+				t.Errorf("Expected %q, got %q", compressionSample, actual)
+			}
+		})
+	}
+}
+
+func TestOpenInputRejectsCorruptCompression(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "oplog.json.gz")
+	if err := os.WriteFile(path, []byte(compressionSample), 0o644); err != nil {
+		t.Fatalf("Did not expect an error, but got: %v", err)
+	}
+	if in, err := openInput(path); err == nil {
+		in.Close()
+		t.Errorf("Expected an error for a .gz file that is not gzip compressed")
+	}
+}
+
+func TestIsBSONPath(t *testing.T) {
+	testCases := map[string]bool{
+		"oplog.bson":            true,
+		"dump/local/OPLOG.BSON": true,
+		"oplog.bson.gz":         true,
+		"oplog.bson.zst":        true,
+		"oplog.json":            false,
+		"oplog.json.gz":         false,
+		"oplog.gz":              false,
+		"bson":                  false,
+	}
+	for path, expected := range testCases {
+		if actual := isBSONPath(path); actual != expected {
+			t.Errorf("isBSONPath(%q): expected %v, got %v", path, expected, actual)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package file
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// entryDecoder streams oplog entries from JSON input holding either top level
+// arrays of entries (pretty printed or one array per line) or bare entries
+// (NDJSON or concatenated objects). Only one entry is held in memory at a time.
+type entryDecoder struct {
+	dec     *json.Decoder
+	arrays  bool
+	inArray bool
+}
+
+func newEntryDecoder(r io.Reader) (*entryDecoder, error) {
+	reader := bufio.NewReader(r)
+	first, err := firstNonSpace(reader)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	return &entryDecoder{
+		dec:    json.NewDecoder(reader),
+		arrays: first == '[',
+	}, nil
+}
+
+// Next returns the next entry, or io.EOF once the input is exhausted.
+func (d *entryDecoder) Next() (json.RawMessage, error) {
+	for {
+		if d.inArray {
+			if d.dec.More() {
+				var entry json.RawMessage
+				if err := d.dec.Decode(&entry); err != nil {
+					return nil, fmt.Errorf("decoding oplog entry: %v", err)
+				}
+				return entry, nil
+			}
+			if _, err := d.dec.Token(); err != nil {
+				return nil, fmt.Errorf("decoding oplog array: %v", err)
+			}
+			d.inArray = false
+		}
+
+		if !d.arrays {
+			var entry json.RawMessage
+			if err := d.dec.Decode(&entry); err != nil {
+				if err == io.EOF {
+					return nil, io.EOF
+				}
+				return nil, fmt.Errorf("decoding oplog entry: %v", err)
+			}
+			return entry, nil
+		}
+
+		token, err := d.dec.Token()
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		if err != nil {
+			return nil, fmt.Errorf("decoding oplog array: %v", err)
+		}
+		if delim, ok := token.(json.Delim); !ok || delim != '[' {
+			return nil, fmt.Errorf("expected an array of oplog entries, got %v", token)
+		}
+		d.inArray = true
+	}
+}
+
+// firstNonSpace peeks at the first byte of r that is not JSON whitespace.
+func firstNonSpace(r *bufio.Reader) (byte, error) {
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		switch b {
+		case ' ', '\t', '\r', '\n':
+			continue
+		}
+		return b, r.UnreadByte()
+	}
+}
@@ -0,0 +1,111 @@
+package file
+
+import (
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// decodeAll returns every entry of input, compacted to strings.
+func decodeAll(input string) ([]string, error) {
+	dec, err := newEntryDecoder(strings.NewReader(input))
+	if err != nil {
+		return nil, err
+	}
+	var entries []string
+	for {
+		entry, err := dec.Next()
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return entries, err
+		}
+		entries = append(entries, string(entry))
+	}
+}
+
+func TestEntryDecoder(t *testing.T) {
+	large := strings.Repeat("x", 100*1024)
+	testCases := []struct {
+		name        string
+		input       string
+		expected    []string
+		expectedErr bool
+	}{
+		{
+			name:     "pretty printed array",
+			input:    "[\n  {\"op\": \"i\"},\n  {\"op\": \"u\"}\n]\n",
+			expected: []string{`{"op": "i"}`, `{"op": "u"}`},
+		},
+		{
+			name:     "one array per line",
+			input:    "[{\"op\": \"i\"}]\n[{\"op\": \"u\"}, {\"op\": \"d\"}]\n",
+			expected: []string{`{"op": "i"}`, `{"op": "u"}`, `{"op": "d"}`},
+		},
+		{
+			name:     "NDJSON",
+			input:    "{\"op\": \"i\"}\n{\"op\": \"u\"}\n",
+			expected: []string{`{"op": "i"}`, `{"op": "u"}`},
+		},
+		{
+			name:     "concatenated objects",
+			input:    `{"op": "i"}{"op": "u"} {"op": "d"}`,
+			expected: []string{`{"op": "i"}`, `{"op": "u"}`, `{"op": "d"}`},
+		},
+		{
+			name:     "leading whitespace before an array",
+			input:    "\n\t [{\"op\": \"i\"}]",
+			expected: []string{`{"op": "i"}`},
+		},
+		{
+			name:     "empty array",
+			input:    "[]",
+			expected: nil,
+		},
+		{
+			name:     "empty input",
+			input:    "  \n",
+			expected: nil,
+		},
+		{
+			name:     "entry larger than 64KB",
+			input:    `[{"op": "i", "o": {"note": "` + large + `"}}]`,
+			expected: []string{`{"op": "i", "o": {"note": "` + large + `"}}`},
+		},
+		{
+			name:        "truncated array",
+			input:       `[{"op": "i"}, {"op": "u"`,
+			expected:    []string{`{"op": "i"}`},
+			expectedErr: true,
+		},
+		{
+			name:        "malformed entry",
+			input:       "{\"op\": \"i\"}\n{\"op\" \"u\"}\n",
+			expected:    []string{`{"op": "i"}`},
+			expectedErr: true,
+		},
+		{
+			name:        "scalar between arrays",
+			input:       `[{"op": "i"}] 42`,
+			expected:    []string{`{"op": "i"}`},
+			expectedErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			actual, err := decodeAll(tc.input)
+			if tc.expectedErr && err == nil {
+				t.Errorf("Expected an error, got none")
+			}
+			if !tc.expectedErr && err != nil {
+				t.Errorf("Did not expect an error, but got: %v", err)
+			}
+			if !reflect.DeepEqual(actual, tc.expected) {
+				t.Errorf("Entries mismatch:\nExpected: %q\nActual  : %q", tc.expected, actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package file
+
+import (
+	"container/heap"
+	"encoding/json"
+	"fmt"
+	"io"
+	"op-log-parser/application/domain/models"
+)
+
+// entrySource yields oplog entries until it returns io.EOF.
+type entrySource interface {
+	Next() (json.RawMessage, error)
+}
+
+// mergeSource is the head entry of one input file in a merge.
+type mergeSource struct {
+	source entrySource
+	name   string
+	index  int
+	entry  json.RawMessage
+	ts     models.Timestamp
+}
+
+// advance loads the next entry of the source, reporting false at its end.
+func (s *mergeSource) advance() (bool, error) {
+	entry, err := s.source.Next()
+	if err == io.EOF {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("%s: %v", s.name, err)
+	}
+	ts, err := entryTimestamp(entry)
+	if err != nil {
+		return false, fmt.Errorf("%s: %v", s.name, err)
+	}
+	s.entry, s.ts = entry, ts
+	return true, nil
+}
+
+// entryTimestamp returns the ts of an oplog entry, zero when it has none.
+func entryTimestamp(entry json.RawMessage) (models.Timestamp, error) {
+	var header struct {
+		Timestamp models.Timestamp `json:"ts"`
+	}
+	if err := json.Unmarshal(entry, &header); err != nil {
+		return models.Timestamp{}, fmt.Errorf("reading oplog ts: %v", err)
+	}
+	return header.Timestamp, nil
+}
+
+// mergeHeap orders sources by the ts of their head entry. Entries with the
+// same ts keep the order of the input files.
+type mergeHeap []*mergeSource
+
+func (h mergeHeap) Len() int { return len(h) }
+
+func (h mergeHeap) Less(i, j int) bool {
+	if h[i].ts != h[j].ts {
+		return h[i].ts.Before(h[j].ts)
+	}
+	return h[i].index < h[j].index
+}
+
+func (h mergeHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *mergeHeap) Push(x any) { *h = append(*h, x.(*mergeSource)) }
+
+func (h *mergeHeap) Pop() any {
+	old := *h
+	source := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return source
+}
+
+// merger is a k-way merge of several inputs, each ordered by ts, into a
+// single stream ordered by ts.
+type merger struct {
+	sources mergeHeap
+	started bool
+	pending []*mergeSource
+}
+
+func newMerger(names []string, sources []entrySource) *merger {
+	pending := make([]*mergeSource, len(sources))
+	for i, source := range sources {
+		pending[i] = &mergeSource{source: source, name: names[i], index: i}
+	}
+	return &merger{pending: pending}
+}
+
+func (m *merger) Next() (json.RawMessage, error) {
+	if !m.started {
+		m.started = true
+		for _, source := range m.pending {
+			ok, err := source.advance()
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				m.sources = append(m.sources, source)
+			}
+		}
+		m.pending = nil
+		heap.Init(&m.sources)
+	}
+	if len(m.sources) == 0 {
+		return nil, io.EOF
+	}
+
+	head := m.sources[0]
+	entry := head.entry
+	ok, err := head.advance()
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		heap.Fix(&m.sources, 0)
+	} else {
+		heap.Pop(&m.sources)
+	}
+	return entry, nil
+}
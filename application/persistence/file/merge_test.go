@@ -0,0 +1,195 @@
+package file
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// sliceSource yields its entries, then err or io.EOF.
+type sliceSource struct {
+	entries []string
+	err     error
+}
+
+func (s *sliceSource) Next() (json.RawMessage, error) {
+	if len(s.entries) == 0 {
+		if s.err != nil {
+			return nil, s.err
+		}
+		return nil, io.EOF
+	}
+	entry := s.entries[0]
+	s.entries = s.entries[1:]
+	return json.RawMessage(entry), nil
+}
+
+func entryAt(name string, t, i int) string {
+	return fmt.Sprintf(`{"name": %q, "ts": {"T": %d, "I": %d}}`, name, t, i)
+}
+
+// mergeNames merges sources and returns the names of the entries in order.
+func mergeNames(sources ...*sliceSource) ([]string, error) {
+	names := make([]string, len(sources))
+	entrySources := make([]entrySource, len(sources))
+	for i, source := range sources {
+		names[i] = fmt.Sprintf("source%d", i)
+		entrySources[i] = source
+	}
+	merged := newMerger(names, entrySources)
+
+	var order []string
+	for {
+		entry, err := merged.Next()
+		if err == io.EOF {
+			return order, nil
+		}
+		if err != nil {
+			return order, err
+		}
+		var header struct {
+			Name string `json:"name"`
+		}
+		if err := json.Unmarshal(entry, &header); err != nil {
+			return order, err
+		}
+		order = append(order, header.Name)
+	}
+}
+
+func TestMerger(t *testing.T) {
+	testCases := []struct {
+		name     string
+		sources  []*sliceSource
+		expected []string
+	}{
+		{
+			name: "entries are ordered by ts across sources",
+			sources: []*sliceSource{
+				{entries: []string{entryAt("a1", 1, 1), entryAt("a2", 3, 1), entryAt("a3", 5, 1)}},
+				{entries: []string{entryAt("b1", 2, 1), entryAt("b2", 4, 1)}},
+			},
+			expected: []string{"a1", "b1", "a2", "b2", "a3"},
+		},
+		{
+			name: "the ordinal orders entries of the same second",
+			sources: []*sliceSource{
+				{entries: []string{entryAt("a1", 1, 2)}},
+				{entries: []string{entryAt("b1", 1, 1), entryAt("b2", 1, 3)}},
+			},
+			expected: []string{"b1", "a1", "b2"},
+		},
+		{
+			name: "equal ts keep the order of the sources",
+			sources: []*sliceSource{
+				{entries: []string{entryAt("a1", 1, 1), entryAt("a2", 2, 1)}},
+				{entries: []string{entryAt("b1", 1, 1), entryAt("b2", 2, 1)}},
+				{entries: []string{entryAt("c1", 1, 1)}},
+			},
+			expected: []string{"a1", "b1", "c1", "a2", "b2"},
+		},
+		{
+			name: "empty sources are skipped",
+			sources: []*sliceSource{
+				{},
+				{entries: []string{entryAt("b1", 1, 1)}},
+			},
+			expected: []string{"b1"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			actual, err := mergeNames(tc.sources...)
+			if err != nil {
+				t.Fatalf("Did not expect an error, but got: %v", err)
+			}
+			if !reflect.DeepEqual(actual, tc.expected) {
+				t.Errorf("Order mismatch:\nExpected: %v\nActual  : %v", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestMergerSourceErrors(t *testing.T) {
+	testCases := []struct {
+		name     string
+		sources  []*sliceSource
+		expected []string
+		errText  string
+	}{
+		{
+			name: "a failing source stops the merge with its name",
+			sources: []*sliceSource{
+				{entries: []string{entryAt("a1", 1, 1), entryAt("a2", 3, 1)}},
+				{entries: []string{entryAt("b1", 2, 1)}, err: errors.New("truncated")},
+			},
+			expected: []string{"a1"},
+			errText:  "source1: truncated",
+		},
+		{
+			name: "a failing first entry fails the merge",
+			sources: []*sliceSource{
+				{err: errors.New("unreadable")},
+			},
+			errText: "source0: unreadable",
+		},
+		{
+			name: "an entry with a malformed ts fails the merge",
+			sources: []*sliceSource{
+				{entries: []string{`{"name": "a1", "ts": "soon"}`}},
+			},
+			errText: "source0: reading oplog ts",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			actual, err := mergeNames(tc.sources...)
+			if err == nil || !strings.Contains(err.Error(), tc.errText) {
+				t.Errorf("Expected an error containing %q, got %v", tc.errText, err)
+			}
+			if !reflect.DeepEqual(actual, tc.expected) {
+				t.Errorf("Order mismatch:\nExpected: %v\nActual  : %v", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestInputPaths(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"b.json", "a.bson.gz", "c.ndjson.zst", "d.jsonl", "README.md", "notes.txt", ".hidden.json"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o644); err != nil {
+			t.Fatalf("Did not expect an error, but got: %v", err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, "nested.json"), 0o755); err != nil {
+		t.Fatalf("Did not expect an error, but got: %v", err)
+	}
+
+	actual, err := inputPaths(dir)
+	if err != nil {
+		t.Fatalf("Did not expect an error, but got: %v", err)
+	}
+	var expected []string
+	for _, name := range []string{"a.bson.gz", "b.json", "c.ndjson.zst", "d.jsonl"} {
+		expected = append(expected, filepath.Join(dir, name))
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Paths mismatch:\nExpected: %v\nActual  : %v", expected, actual)
+	}
+
+	empty := t.TempDir()
+	if err := os.WriteFile(filepath.Join(empty, "README.md"), nil, 0o644); err != nil {
+		t.Fatalf("Did not expect an error, but got: %v", err)
+	}
+	if _, err := inputPaths(empty); err == nil {
+		t.Errorf("Expected an error for a directory without oplog files")
+	}
+}
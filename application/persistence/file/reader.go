@@ -1,24 +1,88 @@
 package file
 
 import (
-	"bufio"
 	"context"
+	"encoding/json"
+	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
 
 	"op-log-parser/application/ports"
 )
 
 type fileReader struct {
-	file   *os.File
+	files  []*input
 	config ports.ReaderConfig
 }
 
+// NewReader reads the oplog entries of config.FilePath, which may also be a
+// directory or a glob pattern. Entries of several files are merged in ts
+// order.
 func NewReader(config ports.ReaderConfig) (ports.Reader, error) {
-	file, err := os.Open(config.FilePath)
+	paths, err := inputPaths(config.FilePath)
 	if err != nil {
 		return nil, err
 	}
-	return &fileReader{file: file, config: config}, nil
+
+	reader := &fileReader{config: config}
+	for _, path := range paths {
+		file, err := openInput(path)
+		if err != nil {
+			reader.Close()
+			return nil, err
+		}
+		reader.files = append(reader.files, file)
+	}
+	return reader, nil
+}
+
+// oplogExtensions are the extensions of the files read from an input
+// directory, under the compression extension if any.
+var oplogExtensions = []string{".json", ".jsonl", ".ndjson", ".bson"}
+
+// inputPaths expands a directory to the oplog files it contains and a glob
+// pattern to its matches, both in lexical order. Other files in a directory,
+// such as a README, are left out.
+func inputPaths(path string) ([]string, error) {
+	if strings.ContainsAny(path, "*?[") {
+		matches, err := filepath.Glob(path)
+		if err != nil {
+			return nil, fmt.Errorf("invalid input pattern %s: %v", path, err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no input files match %s", path)
+		}
+		sort.Strings(matches)
+		return matches, nil
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{path}, nil
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, entry := range entries {
+		if entry.Type().IsRegular() && !strings.HasPrefix(entry.Name(), ".") &&
+			slices.Contains(oplogExtensions, filepath.Ext(uncompressedName(entry.Name()))) {
+			paths = append(paths, filepath.Join(path, entry.Name()))
+		}
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no input files in %s", path)
+	}
+	return paths, nil
 }
 
 func (r *fileReader) Read(ctx context.Context) (<-chan string, <-chan error) {
@@ -28,26 +92,93 @@ func (r *fileReader) Read(ctx context.Context) (<-chan string, <-chan error) {
 	go func() {
 		defer close(oplogChan)
 		defer close(errChan)
-		defer r.file.Close()
+		defer r.Close()
+
+		source, err := r.entries()
+		if err != nil {
+			errChan <- err
+			return
+		}
+		for {
+			entry, err := source.Next()
+			if err == io.EOF {
+				return
+			}
+			if err != nil {
+				errChan <- err
+				return
+			}
+			skip, stop := r.filter(entry)
+			if stop {
+				return
+			}
+			if skip {
+				continue
+			}
 
-		scanner := bufio.NewScanner(r.file)
-		for scanner.Scan() {
+			// Entries are passed on as single element arrays, as the parser
+			// expects them
 			select {
 			case <-ctx.Done():
 				return
-			default:
-				oplogChan <- scanner.Text()
+			case oplogChan <- "[" + string(entry) + "]":
 			}
 		}
-
-		if err := scanner.Err(); err != nil {
-			errChan <- err
-		}
 	}()
 
 	return oplogChan, errChan
 }
 
+// filter reports whether the entry is skipped, because it is outside the
+// time window or at or before the checkpoint the read resumes from, and
+// whether reading stops because the entry is past the window. Entries
+// without a ts are never filtered.
+func (r *fileReader) filter(entry json.RawMessage) (bool, bool) {
+	if r.config.ResumeFrom == nil && r.config.Since.IsZero() && r.config.Until.IsZero() {
+		return false, false
+	}
+	ts, err := entryTimestamp(entry)
+	if err != nil || ts.IsZero() {
+		return false, false
+	}
+	if r.config.PastWindow(ts) {
+		return true, true
+	}
+	if r.config.ResumeFrom != nil && !r.config.ResumeFrom.Timestamp.Before(ts) {
+		return true, false
+	}
+	return !r.config.InWindow(ts), false
+}
+
+// entries returns the entries of the input file, or the ts ordered merge of
+// the entries of all input files.
+func (r *fileReader) entries() (entrySource, error) {
+	names := make([]string, len(r.files))
+	sources := make([]entrySource, len(r.files))
+	for i, file := range r.files {
+		names[i] = file.name
+		if file.bson {
+			sources[i] = newBSONDecoder(file)
+			continue
+		}
+		decoder, err := newEntryDecoder(file)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", file.name, err)
+		}
+		sources[i] = decoder
+	}
+	if len(sources) == 1 {
+		return sources[0], nil
+	}
+	return newMerger(names, sources), nil
+}
+
 func (r *fileReader) Close() error {
-	return r.file.Close()
+	var firstErr error
+	for _, file := range r.files {
+		if err := file.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
 }
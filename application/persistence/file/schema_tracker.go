@@ -0,0 +1,87 @@
+package file
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"op-log-parser/application/domain/services"
+	"op-log-parser/application/persistence/memory"
+)
+
+// schemaTracker keeps the schema state in a JSON file so it survives restarts.
+// The file is rewritten atomically after every change.
+type schemaTracker struct {
+	*memory.SchemaTracker
+	path string
+}
+
+func NewSchemaTracker(path string) (services.SchemaTracker, error) {
+	state := make(map[string]*memory.NamespaceState)
+
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("reading schema state: %v", err)
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &state); err != nil {
+			return nil, fmt.Errorf("parsing schema state %s: %v", path, err)
+		}
+	}
+
+	return &schemaTracker{
+		SchemaTracker: memory.NewSchemaTrackerWithState(state),
+		path:          path,
+	}, nil
+}
+
+func (t *schemaTracker) MarkDDLGenerated(namespace string) error {
+	if err := t.SchemaTracker.MarkDDLGenerated(namespace); err != nil {
+		return err
+	}
+	return t.save()
+}
+
+func (t *schemaTracker) InitializeColumnTracker(namespace string, columns map[string]string) error {
+	if err := t.SchemaTracker.InitializeColumnTracker(namespace, columns); err != nil {
+		return err
+	}
+	return t.save()
+}
+
+func (t *schemaTracker) UpdateColumnsTracker(namespace string, newColumns map[string]string) error {
+	if err := t.SchemaTracker.UpdateColumnsTracker(namespace, newColumns); err != nil {
+		return err
+	}
+	return t.save()
+}
+
+func (t *schemaTracker) MarkColumnsNullable(namespace string, columns []string) error {
+	if state, exists := t.Namespace(namespace); exists && state.IsNullable(columns) {
+		return nil
+	}
+	if err := t.SchemaTracker.MarkColumnsNullable(namespace, columns); err != nil {
+		return err
+	}
+	return t.save()
+}
+
+func (t *schemaTracker) SetColumnNames(namespace string, names map[string]string) error {
+	if err := t.SchemaTracker.SetColumnNames(namespace, names); err != nil {
+		return err
+	}
+	return t.save()
+}
+
+func (t *schemaTracker) save() error {
+	data, err := json.MarshalIndent(t.State(), "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding schema state: %v", err)
+	}
+
+	if err := writeFileAtomic(t.path, data); err != nil {
+		return fmt.Errorf("writing schema state: %v", err)
+	}
+	return nil
+}
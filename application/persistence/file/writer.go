@@ -13,31 +13,42 @@ type fileWriter struct {
 }
 
 func NewWriter(config ports.WriterConfig) (ports.Writer, error) {
-	file, err := os.Create(config.FilePath)
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if config.Append {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	}
+	file, err := os.OpenFile(config.FilePath, flags, 0666)
 	if err != nil {
 		return nil, err
 	}
 	return &fileWriter{file: file, config: config}, nil
 }
 
-func (w *fileWriter) Write(ctx context.Context, statements <-chan []string) <-chan error {
+func (w *fileWriter) Write(ctx context.Context, batches <-chan ports.Batch) <-chan error {
 	errChan := make(chan error)
 
 	go func() {
 		defer close(errChan)
 		defer w.file.Close()
 
-		for statements := range statements {
+		for batch := range batches {
 			select {
 			case <-ctx.Done():
 				return
 			default:
+				statements := batch.Statements
+				if batch.Transaction && len(statements) > 0 {
+					statements = append(append([]string{"BEGIN"}, statements...), "COMMIT")
+				}
 				for _, stmt := range statements {
 					if _, err := w.file.WriteString(stmt + ";\n"); err != nil {
 						errChan <- err
 						return
 					}
 				}
+				if batch.OnApplied != nil {
+					batch.OnApplied()
+				}
 			}
 		}
 	}()
@@ -0,0 +1,149 @@
+package memory
+
+import (
+	"op-log-parser/application/domain/services"
+)
+
+// NamespaceState is the tracked DDL state of a single schema or table. Columns
+// maps column names to their SQL type, Nullable records the columns that have
+// been observed with a null or unset value and Names maps document field
+// names to column names.
+type NamespaceState struct {
+	DDLGenerated bool              `json:"ddl_generated"`
+	Columns      map[string]string `json:"columns,omitempty"`
+	Nullable     map[string]bool   `json:"nullable,omitempty"`
+	Names        map[string]string `json:"names,omitempty"`
+}
+
+// IsNullable reports whether all columns have already been observed as null.
+func (s *NamespaceState) IsNullable(columns []string) bool {
+	for _, col := range columns {
+		if !s.Nullable[col] {
+			return false
+		}
+	}
+	return true
+}
+
+// SchemaTracker keeps the schema state in memory. It is also embedded by the
+// persistent trackers, which save the state after every change.
+type SchemaTracker struct {
+	namespaces map[string]*NamespaceState
+}
+
+var _ services.SchemaTracker = (*SchemaTracker)(nil)
+
+func NewSchemaTracker() *SchemaTracker {
+	return NewSchemaTrackerWithState(nil)
+}
+
+func NewSchemaTrackerWithState(state map[string]*NamespaceState) *SchemaTracker {
+	if state == nil {
+		state = make(map[string]*NamespaceState)
+	}
+	return &SchemaTracker{namespaces: state}
+}
+
+func (t *SchemaTracker) IsDDLGenerated(namespace string) bool {
+	state, exists := t.namespaces[namespace]
+	return exists && state.DDLGenerated
+}
+
+func (t *SchemaTracker) MarkDDLGenerated(namespace string) error {
+	t.namespace(namespace).DDLGenerated = true
+	return nil
+}
+
+func (t *SchemaTracker) GetKnownColumns(namespace string) map[string]bool {
+	columns := make(map[string]bool)
+	if state, exists := t.namespaces[namespace]; exists {
+		for col := range state.Columns {
+			columns[col] = true
+		}
+	}
+	return columns
+}
+
+func (t *SchemaTracker) GetColumnTypes(namespace string) map[string]string {
+	columns := make(map[string]string)
+	if state, exists := t.namespaces[namespace]; exists {
+		for col, sqlType := range state.Columns {
+			columns[col] = sqlType
+		}
+	}
+	return columns
+}
+
+func (t *SchemaTracker) InitializeColumnTracker(namespace string, columns map[string]string) error {
+	state := t.namespace(namespace)
+	state.Columns = make(map[string]string, len(columns))
+	for col, sqlType := range columns {
+		state.Columns[col] = sqlType
+	}
+	return nil
+}
+
+func (t *SchemaTracker) UpdateColumnsTracker(namespace string, newColumns map[string]string) error {
+	if state, exists := t.namespaces[namespace]; exists && state.Columns != nil {
+		for col, sqlType := range newColumns {
+			state.Columns[col] = sqlType
+		}
+	}
+	return nil
+}
+
+func (t *SchemaTracker) MarkColumnsNullable(namespace string, columns []string) error {
+	state := t.namespace(namespace)
+	if state.Nullable == nil {
+		state.Nullable = make(map[string]bool)
+	}
+	for _, col := range columns {
+		state.Nullable[col] = true
+	}
+	return nil
+}
+
+func (t *SchemaTracker) GetColumnNames(namespace string) map[string]string {
+	names := make(map[string]string)
+	if state, exists := t.namespaces[namespace]; exists {
+		for field, col := range state.Names {
+			names[field] = col
+		}
+	}
+	return names
+}
+
+func (t *SchemaTracker) SetColumnNames(namespace string, names map[string]string) error {
+	state := t.namespace(namespace)
+	if state.Names == nil {
+		state.Names = make(map[string]string)
+	}
+	for field, col := range names {
+		state.Names[field] = col
+	}
+	return nil
+}
+
+// Namespace returns the tracked state of a namespace, if any.
+func (t *SchemaTracker) Namespace(namespace string) (*NamespaceState, bool) {
+	state, exists := t.namespaces[namespace]
+	return state, exists
+}
+
+// State returns the whole tracked state, keyed by namespace.
+func (t *SchemaTracker) State() map[string]*NamespaceState {
+	return t.namespaces
+}
+
+func (t *SchemaTracker) Close() error {
+	return nil
+}
+
+func (t *SchemaTracker) namespace(namespace string) *NamespaceState {
+	state, exists := t.namespaces[namespace]
+	if !exists {
+		state = &NamespaceState{}
+		t.namespaces[namespace] = state
+	}
+	return state
+}
@@ -0,0 +1,313 @@
+package mongo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"op-log-parser/application/domain/models"
+	"op-log-parser/application/ports"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ChangeStreamReader reads changes through a change stream instead of
+// tailing local.oplog.rs, which works on managed clusters and through mongos
+// and only needs the changeStream and find privileges.
+type ChangeStreamReader struct {
+	client *mongo.Client
+	config ports.ReaderConfig
+}
+
+// changeEvent holds the fields of a change event the reader uses.
+type changeEvent struct {
+	ID            bson.M              `bson:"_id"`
+	OperationType string              `bson:"operationType"`
+	ClusterTime   primitive.Timestamp `bson:"clusterTime"`
+	WallTime      *primitive.DateTime `bson:"wallTime"`
+	NS            struct {
+		DB   string `bson:"db"`
+		Coll string `bson:"coll"`
+	} `bson:"ns"`
+	DocumentKey              bson.M `bson:"documentKey"`
+	FullDocument             bson.M `bson:"fullDocument"`
+	FullDocumentBeforeChange bson.M `bson:"fullDocumentBeforeChange"`
+	LSID                     bson.M `bson:"lsid"`
+	TxnNumber                *int64 `bson:"txnNumber"`
+	UpdateDescription        *struct {
+		UpdatedFields bson.M   `bson:"updatedFields"`
+		RemovedFields []string `bson:"removedFields"`
+	} `bson:"updateDescription"`
+}
+
+func NewChangeStreamReader(config ports.ReaderConfig) (ports.Reader, error) {
+	if config.MongoURI == "" {
+		return nil, fmt.Errorf("MongoURI is required")
+	}
+	if config.Collection != "" && config.Database == "" {
+		return nil, fmt.Errorf("a database is required to watch a collection")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(config.MongoURI))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to MongoDB: %v", err)
+	}
+
+	err = client.Ping(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to ping MongoDB: %v", err)
+	}
+
+	log.Println("Connected to MongoDB")
+	return &ChangeStreamReader{
+		client: client,
+		config: config,
+	}, nil
+}
+
+func (r *ChangeStreamReader) Read(ctx context.Context) (<-chan string, <-chan error) {
+	oplogChan := make(chan string)
+	errChan := make(chan error, 1)
+
+	go func() {
+		defer close(oplogChan)
+		defer close(errChan)
+
+		stream, err := r.watch(ctx)
+		if err != nil {
+			log.Printf("Error opening change stream: %v", err)
+			errChan <- fmt.Errorf("opening change stream: %w", historyLost(err))
+			return
+		}
+		defer stream.Close(ctx)
+		log.Println("Change stream opened, waiting for changes...")
+
+		if err := r.streamChanges(ctx, stream, oplogChan); err != nil {
+			log.Printf("Error streaming changes: %v", err)
+			errChan <- fmt.Errorf("streaming changes: %w", historyLost(err))
+		}
+	}()
+
+	return oplogChan, errChan
+}
+
+// changeStreamHistoryLost is the server error code for a resume point that
+// is no longer in the oplog.
+const changeStreamHistoryLost = 286
+
+// historyLost reports a resume point that rolled off the oplog as
+// ports.ErrOplogGap.
+func historyLost(err error) error {
+	var serverErr mongo.ServerError
+	if errors.As(err, &serverErr) && serverErr.HasErrorCode(changeStreamHistoryLost) {
+		return fmt.Errorf("%w: %v", ports.ErrOplogGap, err)
+	}
+	return err
+}
+
+// watch opens the change stream at cluster, database or collection scope.
+func (r *ChangeStreamReader) watch(ctx context.Context) (*mongo.ChangeStream, error) {
+	opts := options.ChangeStream()
+	if r.config.FullDocument != "" {
+		opts.SetFullDocument(options.FullDocument(r.config.FullDocument))
+	}
+	if r.config.FullDocumentBeforeChange != "" {
+		opts.SetFullDocumentBeforeChange(options.FullDocument(r.config.FullDocumentBeforeChange))
+	}
+	if resume := r.config.ResumeFrom; resume != nil {
+		if resume.ResumeToken != nil {
+			opts.SetResumeAfter(bson.M(resume.ResumeToken))
+		} else {
+			// startAtOperationTime is inclusive, the checkpoint entry itself
+			// was already applied
+			opts.SetStartAtOperationTime(&primitive.Timestamp{T: resume.Timestamp.T, I: resume.Timestamp.I + 1})
+		}
+	} else if since := r.config.Since; !since.IsZero() {
+		opts.SetStartAtOperationTime(&primitive.Timestamp{T: since.T, I: since.I})
+	}
+
+	pipeline := mongo.Pipeline{}
+	switch {
+	case r.config.Collection != "":
+		return r.client.Database(r.config.Database).Collection(r.config.Collection).Watch(ctx, pipeline, opts)
+	case r.config.Database != "":
+		return r.client.Database(r.config.Database).Watch(ctx, pipeline, opts)
+	default:
+		return r.client.Watch(ctx, pipeline, opts)
+	}
+}
+
+func (r *ChangeStreamReader) streamChanges(ctx context.Context, stream *mongo.ChangeStream, oplogChan chan<- string) error {
+	for stream.Next(ctx) {
+		var event changeEvent
+		if err := stream.Decode(&event); err != nil {
+			return fmt.Errorf("decoding change event: %v", err)
+		}
+		if event.OperationType == "invalidate" {
+			return fmt.Errorf("change stream invalidated")
+		}
+		ts := models.Timestamp{T: event.ClusterTime.T, I: event.ClusterTime.I}
+		if r.config.PastWindow(ts) {
+			log.Println("Reached the end of the time window")
+			return nil
+		}
+		if !r.config.InWindow(ts) {
+			continue
+		}
+
+		entry, ok, err := oplogEntry(event, r.config.FullDocument == string(options.UpdateLookup))
+		if err != nil {
+			return err
+		}
+		if !ok {
+			log.Printf("Skipping %s change event", event.OperationType)
+			continue
+		}
+
+		data, err := encodeEntry(entry)
+		if err != nil {
+			return fmt.Errorf("encoding change event: %v", err)
+		}
+		select {
+		case oplogChan <- data:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if err := stream.Err(); err != nil && ctx.Err() == nil {
+		return err
+	}
+	return ctx.Err()
+}
+
+// oplogEntry converts a change event into the oplog entry for the same
+// change. Events without a document level equivalent are not converted, nor
+// are updates whose looked up document was deleted since, the delete
+// follows. lookup tells whether the stream looks up full documents.
+func oplogEntry(event changeEvent, lookup bool) (bson.M, bool, error) {
+	entry := bson.M{
+		"ns":          event.NS.DB + "." + event.NS.Coll,
+		"ts":          event.ClusterTime,
+		"resumeToken": event.ID,
+	}
+	if event.WallTime != nil {
+		entry["wall"] = *event.WallTime
+	}
+	if event.LSID != nil && event.TxnNumber != nil {
+		entry["lsid"] = event.LSID
+		entry["txnNumber"] = *event.TxnNumber
+	}
+	if event.FullDocumentBeforeChange != nil {
+		entry["preImage"] = event.FullDocumentBeforeChange
+	}
+	id := event.DocumentKey["_id"]
+
+	switch event.OperationType {
+	case "insert":
+		entry["op"] = "i"
+		entry["o"] = event.FullDocument
+	case "update", "replace":
+		if lookup && event.OperationType == "update" && event.FullDocument == nil {
+			return nil, false, nil
+		}
+		set, unset, err := updatedFields(event)
+		if err != nil {
+			return nil, false, err
+		}
+		diff := bson.M{}
+		if len(set) > 0 {
+			diff["u"] = set
+		}
+		if len(unset) > 0 {
+			diff["d"] = unset
+		}
+		entry["op"] = "u"
+		entry["o"] = bson.M{"$v": 2, "diff": diff}
+		entry["o2"] = bson.M{"_id": id}
+	case "delete":
+		entry["op"] = "d"
+		entry["o"] = bson.M{"_id": id}
+	default:
+		return nil, false, nil
+	}
+	return entry, true, nil
+}
+
+// updatedFields returns the set and unset fields of an update or replace. A
+// replace sets every field of the new document and unsets the fields only
+// the pre-image has; without a pre-image the dropped fields are not known
+// and keep their value. For an update the looked up full document, when
+// there is one, resolves changes of nested paths to their top level field.
+// Without it a nested path cannot be mapped to a column and is an error.
+func updatedFields(event changeEvent) (bson.M, bson.M, error) {
+	set := bson.M{}
+	unset := bson.M{}
+	if event.OperationType == "replace" {
+		for field, value := range event.FullDocument {
+			if field != "_id" {
+				set[field] = value
+			}
+		}
+		for field := range event.FullDocumentBeforeChange {
+			if _, ok := event.FullDocument[field]; !ok {
+				unset[field] = false
+			}
+		}
+		return set, unset, nil
+	}
+	if event.UpdateDescription == nil {
+		return set, unset, nil
+	}
+	if event.FullDocument == nil {
+		paths := slices.Concat(slices.Sorted(maps.Keys(event.UpdateDescription.UpdatedFields)), event.UpdateDescription.RemovedFields)
+		for _, path := range paths {
+			if strings.Contains(path, ".") {
+				return nil, nil, fmt.Errorf("update of nested field %s needs the updateLookup full document", path)
+			}
+		}
+	}
+
+	changed := func(path string) {
+		field, _, _ := strings.Cut(path, ".")
+		if value, ok := event.FullDocument[field]; ok {
+			set[field] = value
+		} else {
+			unset[field] = false
+		}
+	}
+	for path, value := range event.UpdateDescription.UpdatedFields {
+		if event.FullDocument != nil {
+			changed(path)
+		} else {
+			set[path] = value
+		}
+	}
+	for _, path := range event.UpdateDescription.RemovedFields {
+		if event.FullDocument != nil {
+			changed(path)
+		} else {
+			unset[path] = false
+		}
+	}
+	return set, unset, nil
+}
+
+func (r *ChangeStreamReader) Close() error {
+	if r.client == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return r.client.Disconnect(ctx)
+}
@@ -0,0 +1,194 @@
+package mongo
+
+import (
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// updateEvent returns an update event of test.student 1 with the given
+// update description and looked up document.
+func updateEvent(updated bson.M, removed []string, fullDocument bson.M) changeEvent {
+	event := changeEvent{
+		OperationType: "update",
+		DocumentKey:   bson.M{"_id": "1"},
+		FullDocument:  fullDocument,
+	}
+	event.UpdateDescription = &struct {
+		UpdatedFields bson.M   `bson:"updatedFields"`
+		RemovedFields []string `bson:"removedFields"`
+	}{UpdatedFields: updated, RemovedFields: removed}
+	return event
+}
+
+func TestUpdatedFields(t *testing.T) {
+	testCases := []struct {
+		name          string
+		event         changeEvent
+		expectedSet   bson.M
+		expectedUnset bson.M
+		expectedErr   string
+	}{
+		{
+			name:          "top level fields without lookup",
+			event:         updateEvent(bson.M{"name": "Sel"}, []string{"age"}, nil),
+			expectedSet:   bson.M{"name": "Sel"},
+			expectedUnset: bson.M{"age": false},
+		},
+		{
+			name:        "nested updated field without lookup",
+			event:       updateEvent(bson.M{"address.zip": "1"}, nil, nil),
+			expectedErr: "update of nested field address.zip needs the updateLookup full document",
+		},
+		{
+			name:        "nested removed field without lookup",
+			event:       updateEvent(nil, []string{"address.zip"}, nil),
+			expectedErr: "update of nested field address.zip needs the updateLookup full document",
+		},
+		{
+			name:          "nested paths resolve to their top level field with lookup",
+			event:         updateEvent(bson.M{"address.zip": "1"}, []string{"phone.work"}, bson.M{"_id": "1", "address": bson.M{"zip": "1"}}),
+			expectedSet:   bson.M{"address": bson.M{"zip": "1"}},
+			expectedUnset: bson.M{"phone": false},
+		},
+		{
+			name: "replace with a pre-image unsets the dropped fields",
+			event: changeEvent{
+				OperationType:            "replace",
+				FullDocument:             bson.M{"_id": "1", "name": "Sel"},
+				FullDocumentBeforeChange: bson.M{"_id": "1", "name": "Selena", "age": 20},
+			},
+			expectedSet:   bson.M{"name": "Sel"},
+			expectedUnset: bson.M{"age": false},
+		},
+		{
+			name: "replace without a pre-image only sets fields",
+			event: changeEvent{
+				OperationType: "replace",
+				FullDocument:  bson.M{"_id": "1", "name": "Sel"},
+			},
+			expectedSet:   bson.M{"name": "Sel"},
+			expectedUnset: bson.M{},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			set, unset, err := updatedFields(tc.event)
+			if tc.expectedErr != "" {
+				if err == nil || err.Error() != tc.expectedErr {
+					t.Errorf("Expected error '%v', but got '%v'", tc.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Did not expect an error, but got: %v", err)
+			}
+			if !reflect.DeepEqual(set, tc.expectedSet) {
+				t.Errorf("Set mismatch:\nExpected: %v\nActual  : %v", tc.expectedSet, set)
+			}
+			if !reflect.DeepEqual(unset, tc.expectedUnset) {
+				t.Errorf("Unset mismatch:\nExpected: %v\nActual  : %v", tc.expectedUnset, unset)
+			}
+		})
+	}
+}
+
+func TestOplogEntry(t *testing.T) {
+	ts := primitive.Timestamp{T: 1685687329, I: 2}
+	token := bson.M{"_data": "8264"}
+	wall := primitive.DateTime(1685687329457)
+	txnNumber := int64(3)
+	lsid := bson.M{"id": "session"}
+	event := func(operationType string) changeEvent {
+		e := changeEvent{ID: token, OperationType: operationType, ClusterTime: ts, DocumentKey: bson.M{"_id": "1"}}
+		e.NS.DB, e.NS.Coll = "test", "student"
+		return e
+	}
+
+	testCases := []struct {
+		name     string
+		event    changeEvent
+		lookup   bool
+		expected bson.M
+		skipped  bool
+	}{
+		{
+			name: "insert with session and wall time",
+			event: func() changeEvent {
+				e := event("insert")
+				e.FullDocument = bson.M{"_id": "1", "name": "Selena"}
+				e.WallTime = &wall
+				e.LSID, e.TxnNumber = lsid, &txnNumber
+				return e
+			}(),
+			expected: bson.M{
+				"op": "i", "ns": "test.student", "ts": ts, "resumeToken": token, "wall": wall,
+				"lsid": lsid, "txnNumber": txnNumber,
+				"o": bson.M{"_id": "1", "name": "Selena"},
+			},
+		},
+		{
+			name: "update with pre-image",
+			event: func() changeEvent {
+				e := updateEvent(bson.M{"name": "Sel"}, []string{"age"}, nil)
+				e.ID, e.ClusterTime, e.NS = token, ts, event("update").NS
+				e.FullDocumentBeforeChange = bson.M{"_id": "1", "name": "Selena", "age": 20}
+				return e
+			}(),
+			expected: bson.M{
+				"op": "u", "ns": "test.student", "ts": ts, "resumeToken": token,
+				"preImage": bson.M{"_id": "1", "name": "Selena", "age": 20},
+				"o":        bson.M{"$v": 2, "diff": bson.M{"u": bson.M{"name": "Sel"}, "d": bson.M{"age": false}}},
+				"o2":       bson.M{"_id": "1"},
+			},
+		},
+		{
+			name: "update whose looked up document was deleted",
+			event: func() changeEvent {
+				e := updateEvent(bson.M{"address.zip": "1"}, nil, nil)
+				e.NS = event("update").NS
+				return e
+			}(),
+			lookup:  true,
+			skipped: true,
+		},
+		{
+			name:  "delete",
+			event: event("delete"),
+			expected: bson.M{
+				"op": "d", "ns": "test.student", "ts": ts, "resumeToken": token,
+				"o": bson.M{"_id": "1"},
+			},
+		},
+		{
+			name:    "drop has no document level equivalent",
+			event:   event("drop"),
+			skipped: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			entry, ok, err := oplogEntry(tc.event, tc.lookup)
+			if err != nil {
+				t.Fatalf("Did not expect an error, but got: %v", err)
+			}
+			if ok == tc.skipped {
+				t.Fatalf("Expected skipped to be %v, got entry %v", tc.skipped, entry)
+			}
+			if !tc.skipped && !reflect.DeepEqual(entry, tc.expected) {
+				t.Errorf("Entry mismatch:\nExpected: %v\nActual  : %v", tc.expected, entry)
+			}
+		})
+	}
+}
+
+func TestOplogEntryRejectsNestedUpdatesWithoutLookup(t *testing.T) {
+	event := updateEvent(bson.M{"address.zip": "1"}, nil, nil)
+	if _, _, err := oplogEntry(event, false); err == nil {
+		t.Errorf("Expected an error for a nested update without updateLookup")
+	}
+}
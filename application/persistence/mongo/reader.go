@@ -2,21 +2,43 @@ package mongo
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"time"
 
+	"op-log-parser/application/domain/models"
+	"op-log-parser/application/persistence/bsonjson"
 	"op-log-parser/application/ports"
 
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// initialBackoff and maxBackoff bound the wait before the oplog cursor is
+// reopened after an error, doubling on every failed attempt.
+const (
+	initialBackoff = time.Second
+	maxBackoff     = time.Minute
+)
+
+// maxAttempts bounds the reads failing in a row without reading an entry.
+const maxAttempts = 10
+
+// errBadEntry marks an oplog entry that cannot be decoded or encoded. It
+// fails the same way on every read, a reconnect does not get past it.
+var errBadEntry = errors.New("bad oplog entry")
+
 type MongoReader struct {
 	client *mongo.Client
 	config ports.ReaderConfig
+	// lastSeen is the ts of the last oplog entry read, where a reopened
+	// cursor continues from.
+	lastSeen primitive.Timestamp
+	// wait sleeps for the backoff before a reconnect, or until ctx is done.
+	wait func(ctx context.Context, d time.Duration) error
 }
 
 func NewReader(config ports.ReaderConfig) (ports.Reader, error) {
@@ -38,10 +60,15 @@ func NewReader(config ports.ReaderConfig) (ports.Reader, error) {
 	}
 
 	log.Println("Connected to MongoDB")
-	return &MongoReader{
+	reader := &MongoReader{
 		client: client,
 		config: config,
-	}, nil
+		wait:   waitBackoff,
+	}
+	if config.ResumeFrom != nil {
+		reader.lastSeen = primitive.Timestamp{T: config.ResumeFrom.Timestamp.T, I: config.ResumeFrom.Timestamp.I}
+	}
+	return reader, nil
 }
 
 func (r *MongoReader) Read(ctx context.Context) (<-chan string, <-chan error) {
@@ -55,6 +82,12 @@ func (r *MongoReader) Read(ctx context.Context) (<-chan string, <-chan error) {
 		collection := r.client.Database("local").Collection("oplog.rs")
 		log.Println("Starting oplog streaming...")
 
+		if err := r.checkGap(ctx, collection); err != nil {
+			log.Printf("Error resuming oplog: %v", err)
+			errChan <- err
+			return
+		}
+
 		var lastTimestamp bson.M
 		err := collection.FindOne(ctx, bson.M{}, options.FindOne().SetSort(bson.M{"$natural": -1})).Decode(&lastTimestamp)
 		if err != nil {
@@ -64,15 +97,45 @@ func (r *MongoReader) Read(ctx context.Context) (<-chan string, <-chan error) {
 		}
 		log.Printf("Latest oplog timestamp: %v", lastTimestamp)
 
-		if err := r.processExistingOplogs(ctx, collection, oplogChan); err != nil {
-			log.Printf("Error processing existing oplogs: %v", err)
-			errChan <- fmt.Errorf("processing existing oplogs: %v", err)
-			return
+		if r.snapshotPending() {
+			// A new snapshot starts at the latest entry, a resumed one at the
+			// position it recorded
+			if r.config.ResumeFrom == nil {
+				latest, ok := lastTimestamp["ts"].(primitive.Timestamp)
+				if !ok {
+					errChan <- fmt.Errorf("reading latest oplog timestamp: unexpected %T", lastTimestamp["ts"])
+					return
+				}
+				r.lastSeen = latest
+			}
+			if err := r.snapshot(ctx, r.lastSeen, oplogChan); err != nil {
+				log.Printf("Error taking snapshot: %v", err)
+				errChan <- fmt.Errorf("taking snapshot: %v", err)
+				return
+			}
+		} else {
+			// A failed scan is continued by the tailable cursor from the last
+			// entry read
+			if err := r.processExistingOplogs(ctx, collection, oplogChan, r.existingFilter(lastTimestamp["ts"])); err != nil {
+				if ctx.Err() != nil {
+					errChan <- ctx.Err()
+					return
+				}
+				log.Printf("Error processing existing oplogs: %v", err)
+			} else if latest, ok := lastTimestamp["ts"].(primitive.Timestamp); ok {
+				if r.config.PastWindow(models.Timestamp{T: latest.T, I: latest.I}) {
+					log.Println("Reached the end of the time window")
+					return
+				}
+				if latest.After(r.lastSeen) {
+					r.lastSeen = latest
+				}
+			}
 		}
 
-		if err := r.streamNewOplogs(ctx, collection, oplogChan, lastTimestamp["ts"]); err != nil {
+		if err := r.tail(ctx, collection, oplogChan); err != nil {
 			log.Printf("Error streaming new oplogs: %v", err)
-			errChan <- fmt.Errorf("streaming new oplogs: %v", err)
+			errChan <- fmt.Errorf("streaming new oplogs: %w", err)
 			return
 		}
 	}()
@@ -80,8 +143,33 @@ func (r *MongoReader) Read(ctx context.Context) (<-chan string, <-chan error) {
 	return oplogChan, errChan
 }
 
-func (r *MongoReader) processExistingOplogs(ctx context.Context, collection *mongo.Collection, oplogChan chan<- string) error {
-	cursor, err := collection.Find(ctx, bson.M{}, options.Find().
+// existingFilter selects the entries up to the latest one, which the tailable
+// cursor continues from, and after the checkpoint when the read resumes.
+func (r *MongoReader) existingFilter(lastTimestamp interface{}) bson.M {
+	ts := r.windowFilter()
+	ts["$lte"] = lastTimestamp
+	if r.config.ResumeFrom != nil {
+		resume := r.config.ResumeFrom.Timestamp
+		ts["$gt"] = primitive.Timestamp{T: resume.T, I: resume.I}
+		log.Printf("Resuming after oplog timestamp %d:%d", resume.T, resume.I)
+	}
+	return bson.M{"ts": ts}
+}
+
+// windowFilter returns the ts conditions of the Since and Until bounds.
+func (r *MongoReader) windowFilter() bson.M {
+	ts := bson.M{}
+	if since := r.config.Since; !since.IsZero() {
+		ts["$gte"] = primitive.Timestamp{T: since.T, I: since.I}
+	}
+	if until := r.config.Until; !until.IsZero() {
+		ts["$lt"] = primitive.Timestamp{T: until.T, I: until.I}
+	}
+	return ts
+}
+
+func (r *MongoReader) processExistingOplogs(ctx context.Context, collection *mongo.Collection, oplogChan chan<- string, filter bson.M) error {
+	cursor, err := collection.Find(ctx, filter, options.Find().
 		SetSort(bson.M{"$natural": 1}))
 	if err != nil {
 		return fmt.Errorf("creating initial cursor: %v", err)
@@ -94,21 +182,24 @@ func (r *MongoReader) processExistingOplogs(ctx context.Context, collection *mon
 		case <-ctx.Done():
 			return ctx.Err()
 		default:
+			// The tailable cursor retries the entry from the last one read
 			var raw bson.M
 			if err := cursor.Decode(&raw); err != nil {
-				log.Printf("Error decoding oplog: %v", err)
-				continue
+				return fmt.Errorf("%w: decoding oplog: %v", errBadEntry, err)
 			}
 
 			if ns, ok := raw["ns"].(string); ok && ns == "local.oplog.rs" {
+				r.seen(raw)
 				continue
 			}
 
-			// Wrap the oplog entry in an array
-			oplogArray := []bson.M{raw}
-			dataBytes, _ := json.Marshal(oplogArray)
+			data, err := encodeEntry(raw)
+			if err != nil {
+				return fmt.Errorf("%w: encoding oplog: %v", errBadEntry, err)
+			}
 			select {
-			case oplogChan <- string(dataBytes):
+			case oplogChan <- data:
+				r.seen(raw)
 			case <-ctx.Done():
 				return ctx.Err()
 			}
@@ -118,12 +209,109 @@ func (r *MongoReader) processExistingOplogs(ctx context.Context, collection *mon
 	return cursor.Err()
 }
 
-func (r *MongoReader) streamNewOplogs(ctx context.Context, collection *mongo.Collection, oplogChan chan<- string, lastTimestamp interface{}) error {
-	log.Println("Creating tailable cursor for new oplog entries...")
+// tail streams the entries after r.lastSeen. When the cursor fails it is
+// reopened from the last entry read, unless that entry has rolled off the
+// oplog in the meantime.
+func (r *MongoReader) tail(ctx context.Context, collection *mongo.Collection, oplogChan chan<- string) error {
+	return r.reconnect(ctx, func() error {
+		if err := r.checkGap(ctx, collection); err != nil {
+			return err
+		}
+		return r.streamNewOplogs(ctx, collection, oplogChan)
+	})
+}
+
+// reconnect runs read until it succeeds, fails with ports.ErrOplogGap or
+// errBadEntry, or ctx is done, waiting with exponential backoff between
+// attempts. The backoff starts over once an attempt has read entries; after
+// maxAttempts attempts in a row without any it gives up.
+func (r *MongoReader) reconnect(ctx context.Context, read func() error) error {
+	backoff := initialBackoff
+	attempts := 0
+	for {
+		lastSeen := r.lastSeen
+		err := read()
+		if err == nil || errors.Is(err, ports.ErrOplogGap) || errors.Is(err, errBadEntry) {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
 
-	filter := bson.M{
-		"ts": bson.M{"$gt": lastTimestamp},
+		if r.lastSeen != lastSeen {
+			backoff, attempts = initialBackoff, 0
+		}
+		attempts++
+		if attempts == maxAttempts {
+			return fmt.Errorf("giving up after %d attempts at oplog timestamp %d:%d: %v", attempts, r.lastSeen.T, r.lastSeen.I, err)
+		}
+		log.Printf("Error reading oplog: %v, reconnecting in %v", err, backoff)
+		if err := r.wait(ctx, backoff); err != nil {
+			return err
+		}
+		backoff = min(2*backoff, maxBackoff)
+	}
+}
+
+func waitBackoff(ctx context.Context, d time.Duration) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(d):
+		return nil
+	}
+}
+
+// checkGap returns ports.ErrOplogGap when the oldest oplog entry is newer
+// than the last entry read: the entries in between rolled off the capped
+// oplog before they were read.
+func (r *MongoReader) checkGap(ctx context.Context, collection *mongo.Collection) error {
+	if r.lastSeen.IsZero() {
+		return nil
+	}
+	var oldest struct {
+		TS primitive.Timestamp `bson:"ts"`
+	}
+	err := collection.FindOne(ctx, bson.M{}, options.FindOne().SetSort(bson.M{"$natural": 1})).Decode(&oldest)
+	if err != nil {
+		return fmt.Errorf("getting oldest oplog timestamp: %v", err)
+	}
+	return gapError(r.lastSeen, oldest.TS)
+}
+
+// gapError returns ports.ErrOplogGap when oldest is newer than lastSeen.
+func gapError(lastSeen, oldest primitive.Timestamp) error {
+	if lastSeen.IsZero() || !oldest.After(lastSeen) {
+		return nil
+	}
+	return fmt.Errorf("%w: resume point %d:%d is older than the oldest oplog entry %d:%d",
+		ports.ErrOplogGap, lastSeen.T, lastSeen.I, oldest.T, oldest.I)
+}
+
+// encodeEntry returns entry as the JSON array of one oplog entry the
+// processor reads.
+func encodeEntry(entry bson.M) (string, error) {
+	data, err := bsonjson.Marshal([]any{entry})
+	if err != nil {
+		return "", err
 	}
+	return string(data), nil
+}
+
+// seen records the ts of an entry read.
+func (r *MongoReader) seen(raw bson.M) {
+	if ts, ok := raw["ts"].(primitive.Timestamp); ok {
+		r.lastSeen = ts
+	}
+}
+
+func (r *MongoReader) streamNewOplogs(ctx context.Context, collection *mongo.Collection, oplogChan chan<- string) error {
+	log.Println("Creating tailable cursor for new oplog entries...")
+
+	ts := r.windowFilter()
+	delete(ts, "$lt")
+	ts["$gt"] = r.lastSeen
+	filter := bson.M{"ts": ts}
 
 	tailableCursor, err := collection.Find(ctx, filter, options.Find().
 		SetCursorType(options.TailableAwait).
@@ -145,24 +333,35 @@ func (r *MongoReader) streamNewOplogs(ctx context.Context, collection *mongo.Col
 				if tailableCursor.Err() != nil {
 					return fmt.Errorf("tailable cursor error: %v", tailableCursor.Err())
 				}
+				if tailableCursor.ID() == 0 {
+					return fmt.Errorf("tailable cursor closed by the server")
+				}
 				continue
 			}
 
+			// A reconnect retries the entry from the last one read
 			var raw bson.M
 			if err := tailableCursor.Decode(&raw); err != nil {
-				log.Printf("Error decoding oplog: %v", err)
-				continue
+				return fmt.Errorf("%w: decoding oplog: %v", errBadEntry, err)
+			}
+
+			if ts, ok := raw["ts"].(primitive.Timestamp); ok && r.config.PastWindow(models.Timestamp{T: ts.T, I: ts.I}) {
+				log.Println("Reached the end of the time window")
+				return nil
 			}
 
 			if ns, ok := raw["ns"].(string); ok && ns == "local.oplog.rs" {
+				r.seen(raw)
 				continue
 			}
 
-			// Wrap the oplog entry in an array
-			oplogArray := []bson.M{raw}
-			dataBytes, _ := json.Marshal(oplogArray)
+			data, err := encodeEntry(raw)
+			if err != nil {
+				return fmt.Errorf("%w: encoding oplog: %v", errBadEntry, err)
+			}
 			select {
-			case oplogChan <- string(dataBytes):
+			case oplogChan <- data:
+				r.seen(raw)
 			case <-ctx.Done():
 				return ctx.Err()
 			}
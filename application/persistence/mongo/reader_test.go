@@ -0,0 +1,152 @@
+package mongo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"op-log-parser/application/ports"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestGapError(t *testing.T) {
+	testCases := []struct {
+		name     string
+		lastSeen primitive.Timestamp
+		oldest   primitive.Timestamp
+		gap      bool
+	}{
+		{name: "nothing read yet", oldest: primitive.Timestamp{T: 10, I: 1}},
+		{name: "last entry still in the oplog", lastSeen: primitive.Timestamp{T: 10, I: 2}, oldest: primitive.Timestamp{T: 10, I: 1}},
+		{name: "last entry is the oldest", lastSeen: primitive.Timestamp{T: 10, I: 1}, oldest: primitive.Timestamp{T: 10, I: 1}},
+		{name: "last entry rolled off", lastSeen: primitive.Timestamp{T: 10, I: 1}, oldest: primitive.Timestamp{T: 10, I: 2}, gap: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := gapError(tc.lastSeen, tc.oldest)
+			if tc.gap && !errors.Is(err, ports.ErrOplogGap) {
+				t.Errorf("Expected error '%v', but got '%v'", ports.ErrOplogGap, err)
+			}
+			if !tc.gap && err != nil {
+				t.Errorf("Did not expect an error, but got: %v", err)
+			}
+		})
+	}
+}
+
+// attempt is the outcome of one read of TestReconnect: the entry it reads
+// before failing with err, if any.
+type attempt struct {
+	read uint32
+	err  error
+}
+
+func TestReconnect(t *testing.T) {
+	failure := fmt.Errorf("connection reset")
+	testCases := []struct {
+		name          string
+		attempts      []attempt
+		expectedWaits []time.Duration
+		expectedErr   error
+	}{
+		{
+			name:     "a successful read does not reconnect",
+			attempts: []attempt{{read: 1}},
+		},
+		{
+			name:          "failed reads back off exponentially",
+			attempts:      []attempt{{err: failure}, {err: failure}, {err: failure}, {}},
+			expectedWaits: []time.Duration{time.Second, 2 * time.Second, 4 * time.Second},
+		},
+		{
+			name:          "the backoff is capped",
+			attempts:      []attempt{{err: failure}, {err: failure}, {err: failure}, {err: failure}, {err: failure}, {err: failure}, {err: failure}, {err: failure}, {}},
+			expectedWaits: []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 16 * time.Second, 32 * time.Second, time.Minute, time.Minute},
+		},
+		{
+			name:          "the backoff starts over once entries are read",
+			attempts:      []attempt{{err: failure}, {err: failure}, {read: 1, err: failure}, {err: failure}, {}},
+			expectedWaits: []time.Duration{time.Second, 2 * time.Second, time.Second, 2 * time.Second},
+		},
+		{
+			name:          "an oplog gap is not retried",
+			attempts:      []attempt{{err: failure}, {err: fmt.Errorf("%w: rolled off", ports.ErrOplogGap)}, {}},
+			expectedWaits: []time.Duration{time.Second},
+			expectedErr:   ports.ErrOplogGap,
+		},
+		{
+			name:        "a bad entry is not retried",
+			attempts:    []attempt{{read: 1, err: fmt.Errorf("%w: decoding oplog: corrupt", errBadEntry)}, {}},
+			expectedErr: errBadEntry,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var waits []time.Duration
+			reader := &MongoReader{wait: func(_ context.Context, d time.Duration) error {
+				waits = append(waits, d)
+				return nil
+			}}
+			attempts := tc.attempts
+			err := reader.reconnect(context.Background(), func() error {
+				next := attempts[0]
+				attempts = attempts[1:]
+				if next.read != 0 {
+					reader.lastSeen = primitive.Timestamp{T: reader.lastSeen.T + next.read}
+				}
+				return next.err
+			})
+			if !errors.Is(err, tc.expectedErr) {
+				t.Errorf("Expected error '%v', but got '%v'", tc.expectedErr, err)
+			}
+			if len(attempts) != 0 && tc.expectedErr == nil {
+				t.Errorf("Expected all attempts to run, %d left", len(attempts))
+			}
+			if !reflect.DeepEqual(waits, tc.expectedWaits) {
+				t.Errorf("Waits mismatch:\nExpected: %v\nActual  : %v", tc.expectedWaits, waits)
+			}
+		})
+	}
+}
+
+func TestReconnectStopsWhenContextIsDone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	reader := &MongoReader{wait: waitBackoff}
+	attempts := 0
+	err := reader.reconnect(ctx, func() error {
+		attempts++
+		cancel()
+		return fmt.Errorf("connection reset")
+	})
+	if err != context.Canceled {
+		t.Errorf("Expected error '%v', but got '%v'", context.Canceled, err)
+	}
+	if attempts != 1 {
+		t.Errorf("Expected 1 attempt, got %d", attempts)
+	}
+}
+
+func TestReconnectGivesUpAtTheSameEntry(t *testing.T) {
+	reader := &MongoReader{
+		lastSeen: primitive.Timestamp{T: 10, I: 1},
+		wait:     func(context.Context, time.Duration) error { return nil },
+	}
+	attempts := 0
+	err := reader.reconnect(context.Background(), func() error {
+		attempts++
+		return fmt.Errorf("connection reset")
+	})
+	expected := "giving up after 10 attempts at oplog timestamp 10:1: connection reset"
+	if err == nil || err.Error() != expected {
+		t.Errorf("Expected error '%v', but got '%v'", expected, err)
+	}
+	if attempts != maxAttempts {
+		t.Errorf("Expected %d attempts, got %d", maxAttempts, attempts)
+	}
+}
@@ -0,0 +1,273 @@
+package mongo
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+
+	"op-log-parser/application/domain/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// defaultSnapshotParallelism is the number of collections scanned at a time
+// when the config does not set it.
+const defaultSnapshotParallelism = 4
+
+// systemDatabases are never part of a snapshot.
+var systemDatabases = []string{"admin", "config", "local"}
+
+// snapshotPending reports whether the read starts with a snapshot: a new
+// one, or one interrupted before tailing started.
+func (r *MongoReader) snapshotPending() bool {
+	return r.config.Snapshot && (r.config.ResumeFrom == nil || r.config.ResumeFrom.Snapshot != nil)
+}
+
+// snapshot emits the documents of every included collection as inserts at
+// ts, the oplog position tailing continues from. Collections are scanned in
+// _id order, so an interrupted snapshot resumes each collection from the last
+// document applied. The scan does not read a single point in time: changes
+// made while it runs are replayed from the oplog afterwards, which needs the
+// upserting parser mode. Reading at ts with readConcern snapshot would not
+// help: servers keep that history for minSnapshotHistoryWindowInSeconds, 5
+// minutes by default, less than the scan of a large collection takes.
+func (r *MongoReader) snapshot(ctx context.Context, ts primitive.Timestamp, oplogChan chan<- string) error {
+	namespaces, err := r.snapshotNamespaces(ctx)
+	if err != nil {
+		return err
+	}
+	log.Printf("Starting snapshot of %d collections at oplog timestamp %d:%d", len(namespaces), ts.T, ts.I)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	parallelism := r.config.SnapshotParallelism
+	if parallelism <= 0 {
+		parallelism = defaultSnapshotParallelism
+	}
+
+	var wg sync.WaitGroup
+	var once sync.Once
+	var firstErr error
+	queue := make(chan string)
+	for range parallelism {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for namespace := range queue {
+				if err := r.snapshotCollection(ctx, namespace, ts, oplogChan); err != nil {
+					once.Do(func() {
+						firstErr = fmt.Errorf("snapshot of %s: %v", namespace, err)
+						cancel()
+					})
+					return
+				}
+			}
+		}()
+	}
+
+	for _, namespace := range namespaces {
+		select {
+		case queue <- namespace:
+		case <-ctx.Done():
+		}
+	}
+	close(queue)
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	log.Println("Snapshot completed")
+	return nil
+}
+
+// snapshotNamespaces lists the collections to scan, leaving out those an
+// interrupted snapshot already finished.
+func (r *MongoReader) snapshotNamespaces(ctx context.Context) ([]string, error) {
+	databases, err := r.client.ListDatabaseNames(ctx, bson.M{})
+	if err != nil {
+		return nil, fmt.Errorf("listing databases: %v", err)
+	}
+
+	var namespaces []string
+	for _, database := range databases {
+		if slices.Contains(systemDatabases, database) {
+			continue
+		}
+		collections, err := r.client.Database(database).ListCollectionNames(ctx, bson.M{"type": "collection"})
+		if err != nil {
+			return nil, fmt.Errorf("listing collections of %s: %v", database, err)
+		}
+		namespaces = append(namespaces, r.pendingNamespaces(database, collections)...)
+	}
+	slices.Sort(namespaces)
+	return namespaces, nil
+}
+
+// pendingNamespaces returns the namespaces of the collections of database
+// the snapshot includes and has not finished yet.
+func (r *MongoReader) pendingNamespaces(database string, collections []string) []string {
+	var namespaces []string
+	for _, collection := range collections {
+		namespace := database + "." + collection
+		if strings.HasPrefix(collection, "system.") || !r.snapshotIncludes(namespace) {
+			continue
+		}
+		if position, ok := r.snapshotPosition(namespace); ok && position.Done {
+			continue
+		}
+		namespaces = append(namespaces, namespace)
+	}
+	return namespaces
+}
+
+func (r *MongoReader) snapshotIncludes(namespace string) bool {
+	if len(r.config.SnapshotNamespaces) == 0 {
+		return true
+	}
+	for _, pattern := range r.config.SnapshotNamespaces {
+		if matched, _ := path.Match(pattern, namespace); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// snapshotPosition returns the progress of namespace recorded by an
+// interrupted snapshot.
+func (r *MongoReader) snapshotPosition(namespace string) (models.SnapshotPosition, bool) {
+	if r.config.ResumeFrom == nil {
+		return models.SnapshotPosition{}, false
+	}
+	position, ok := r.config.ResumeFrom.Snapshot[namespace]
+	return position, ok
+}
+
+// snapshotFindOptions scans a collection in _id order. A resumed scan starts
+// at the last applied _id, through an index bound rather than a query so _id
+// values of other BSON types are not skipped; that document is emitted again.
+func (r *MongoReader) snapshotFindOptions(namespace string) (*options.FindOptions, error) {
+	opts := options.Find().
+		SetSort(bson.D{{Key: "_id", Value: 1}}).
+		SetHint(bson.D{{Key: "_id", Value: 1}})
+	if position, ok := r.snapshotPosition(namespace); ok && len(position.LastID) > 0 {
+		var last bson.M
+		if err := bson.UnmarshalExtJSON(position.LastID, true, &last); err != nil {
+			return nil, fmt.Errorf("reading snapshot position: %v", err)
+		}
+		opts.SetMin(bson.D{{Key: "_id", Value: last["_id"]}})
+		log.Printf("Resuming snapshot of %s", namespace)
+	}
+	return opts, nil
+}
+
+// snapshotCollection emits the documents of one collection in _id order.
+// Every insert carries the progress of the scan, the last one marks the
+// collection done. An empty collection is marked done by a no-op entry.
+func (r *MongoReader) snapshotCollection(ctx context.Context, namespace string, ts primitive.Timestamp, oplogChan chan<- string) error {
+	database, name, _ := strings.Cut(namespace, ".")
+	opts, err := r.snapshotFindOptions(namespace)
+	if err != nil {
+		return err
+	}
+
+	cursor, err := r.client.Database(database).Collection(name).Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	var pending bson.M
+	count := 0
+	send := func(entry bson.M) error {
+		data, err := encodeEntry(entry)
+		if err != nil {
+			return fmt.Errorf("encoding document: %v", err)
+		}
+		select {
+		case oplogChan <- data:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	emit := func(doc bson.M, done bool) error {
+		entry, err := snapshotInsert(namespace, ts, doc, done)
+		if err != nil {
+			return err
+		}
+		if err := send(entry); err != nil {
+			return err
+		}
+		count++
+		return nil
+	}
+
+	for cursor.Next(ctx) {
+		var doc bson.M
+		if err := cursor.Decode(&doc); err != nil {
+			return fmt.Errorf("decoding document: %v", err)
+		}
+		if pending != nil {
+			if err := emit(pending, false); err != nil {
+				return err
+			}
+		}
+		pending = doc
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+	if pending != nil {
+		err = emit(pending, true)
+	} else {
+		err = send(snapshotDone(namespace, ts))
+	}
+	if err != nil {
+		return err
+	}
+	log.Printf("Snapshot of %s: %d documents", namespace, count)
+	return nil
+}
+
+// snapshotInsert returns the synthetic insert of doc at ts, carrying the
+// position of doc in the scan of namespace.
+func snapshotInsert(namespace string, ts primitive.Timestamp, doc bson.M, done bool) (bson.M, error) {
+	lastID, err := bson.MarshalExtJSON(bson.M{"_id": doc["_id"]}, true, false)
+	if err != nil {
+		return nil, fmt.Errorf("encoding _id: %v", err)
+	}
+	return bson.M{
+		"op": "i",
+		"ns": namespace,
+		"ts": ts,
+		"o":  doc,
+		"snapshot": models.SnapshotPosition{
+			Namespace: namespace,
+			LastID:    lastID,
+			Done:      done,
+		},
+	}, nil
+}
+
+// snapshotDone returns the no-op entry marking the snapshot of an empty
+// collection done, so a resumed snapshot does not scan it again.
+func snapshotDone(namespace string, ts primitive.Timestamp) bson.M {
+	return bson.M{
+		"op":       "n",
+		"ns":       namespace,
+		"ts":       ts,
+		"o":        bson.M{},
+		"snapshot": models.SnapshotPosition{Namespace: namespace, Done: true},
+	}
+}
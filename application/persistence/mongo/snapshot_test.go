@@ -0,0 +1,215 @@
+package mongo
+
+import (
+	"encoding/json"
+	"math"
+	"reflect"
+	"testing"
+
+	"op-log-parser/application/domain/models"
+	"op-log-parser/application/ports"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestSnapshotIncludes(t *testing.T) {
+	testCases := []struct {
+		name     string
+		patterns []string
+		included map[string]bool
+	}{
+		{
+			name:     "all collections without patterns",
+			included: map[string]bool{"app.users": true, "test.student": true},
+		},
+		{
+			name:     "exact namespace",
+			patterns: []string{"app.users"},
+			included: map[string]bool{"app.users": true, "app.orders": false, "test.users": false},
+		},
+		{
+			name:     "collection wildcard",
+			patterns: []string{"app.*"},
+			included: map[string]bool{"app.users": true, "app.orders": true, "application.users": false},
+		},
+		{
+			name:     "any of several patterns",
+			patterns: []string{"app.users", "test.stud?nt"},
+			included: map[string]bool{"app.users": true, "test.student": true, "test.teacher": false},
+		},
+		{
+			name:     "malformed patterns match nothing",
+			patterns: []string{"app.[users"},
+			included: map[string]bool{"app.users": false},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			reader := &MongoReader{config: ports.ReaderConfig{SnapshotNamespaces: tc.patterns}}
+			for namespace, expected := range tc.included {
+				if actual := reader.snapshotIncludes(namespace); actual != expected {
+					t.Errorf("snapshotIncludes(%q): expected %v, got %v", namespace, expected, actual)
+				}
+			}
+		})
+	}
+}
+
+func TestPendingNamespaces(t *testing.T) {
+	collections := []string{"users", "orders", "system.views", "audit"}
+	testCases := []struct {
+		name     string
+		config   ports.ReaderConfig
+		expected []string
+	}{
+		{
+			name:     "system collections are left out",
+			expected: []string{"app.users", "app.orders", "app.audit"},
+		},
+		{
+			name:     "only included collections",
+			config:   ports.ReaderConfig{SnapshotNamespaces: []string{"app.users", "app.o*"}},
+			expected: []string{"app.users", "app.orders"},
+		},
+		{
+			name: "finished collections of a resumed snapshot are left out",
+			config: ports.ReaderConfig{ResumeFrom: &ports.Checkpoint{Snapshot: map[string]models.SnapshotPosition{
+				"app.users":  {Namespace: "app.users", LastID: json.RawMessage(`{"_id":"1"}`)},
+				"app.orders": {Namespace: "app.orders", LastID: json.RawMessage(`{"_id":"9"}`), Done: true},
+				"app.audit":  {Namespace: "app.audit", Done: true},
+			}}},
+			expected: []string{"app.users"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			reader := &MongoReader{config: tc.config}
+			actual := reader.pendingNamespaces("app", collections)
+			if !reflect.DeepEqual(actual, tc.expected) {
+				t.Errorf("Namespaces mismatch:\nExpected: %v\nActual  : %v", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestSnapshotFindOptionsResumeFromLastID(t *testing.T) {
+	oid, err := primitive.ObjectIDFromHex("647996a1e4b0f1a2b3c4d5e6")
+	if err != nil {
+		t.Fatalf("Did not expect an error, but got: %v", err)
+	}
+	testCases := []struct {
+		name        string
+		position    *models.SnapshotPosition
+		expectedMin interface{}
+		expectedErr bool
+	}{
+		{
+			name: "a new scan has no bound",
+		},
+		{
+			name:     "a collection without progress has no bound",
+			position: &models.SnapshotPosition{Namespace: "app.users"},
+		},
+		{
+			name:        "ObjectID",
+			position:    &models.SnapshotPosition{Namespace: "app.users", LastID: json.RawMessage(`{"_id":{"$oid":"647996a1e4b0f1a2b3c4d5e6"}}`)},
+			expectedMin: bson.D{{Key: "_id", Value: oid}},
+		},
+		{
+			name:        "the BSON type of numbers is kept",
+			position:    &models.SnapshotPosition{Namespace: "app.users", LastID: json.RawMessage(`{"_id":{"$numberLong":"42"}}`)},
+			expectedMin: bson.D{{Key: "_id", Value: int64(42)}},
+		},
+		{
+			name:        "string",
+			position:    &models.SnapshotPosition{Namespace: "app.users", LastID: json.RawMessage(`{"_id":"42"}`)},
+			expectedMin: bson.D{{Key: "_id", Value: "42"}},
+		},
+		{
+			name:        "malformed position",
+			position:    &models.SnapshotPosition{Namespace: "app.users", LastID: json.RawMessage(`{"_id":`)},
+			expectedErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			reader := &MongoReader{}
+			if tc.position != nil {
+				reader.config.ResumeFrom = &ports.Checkpoint{Snapshot: map[string]models.SnapshotPosition{"app.users": *tc.position}}
+			}
+			opts, err := reader.snapshotFindOptions("app.users")
+			if tc.expectedErr {
+				if err == nil {
+					t.Errorf("Expected an error for a malformed snapshot position")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Did not expect an error, but got: %v", err)
+			}
+			if !reflect.DeepEqual(opts.Min, tc.expectedMin) {
+				t.Errorf("Min mismatch:\nExpected: %#v\nActual  : %#v", tc.expectedMin, opts.Min)
+			}
+		})
+	}
+}
+
+func TestSnapshotEntries(t *testing.T) {
+	ts := primitive.Timestamp{T: 1685687329, I: 2}
+	insert, err := snapshotInsert("app.users", ts, bson.M{"_id": int32(7), "score": math.NaN()}, true)
+	if err != nil {
+		t.Fatalf("Did not expect an error, but got: %v", err)
+	}
+	testCases := []struct {
+		name     string
+		entry    bson.M
+		expected string
+	}{
+		{
+			name:     "insert with its position",
+			entry:    insert,
+			expected: `[{"ns":"app.users","o":{"_id":7,"score":"NaN"},"op":"i","snapshot":{"ns":"app.users","lastId":{"_id":{"$numberInt":"7"}},"done":true},"ts":{"T":1685687329,"I":2}}]`,
+		},
+		{
+			name:     "empty collection",
+			entry:    snapshotDone("app.audit", ts),
+			expected: `[{"ns":"app.audit","o":{},"op":"n","snapshot":{"ns":"app.audit","done":true},"ts":{"T":1685687329,"I":2}}]`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			actual, err := encodeEntry(tc.entry)
+			if err != nil {
+				t.Fatalf("Did not expect an error, but got: %v", err)
+			}
+			if actual != tc.expected {
+				t.Errorf("Entry mismatch:\nExpected: %s\nActual  : %s", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestEncodeEntryNonFiniteFloats(t *testing.T) {
+	entry := bson.M{
+		"o": bson.M{
+			"nan":    math.NaN(),
+			"inf":    math.Inf(1),
+			"negInf": float32(math.Inf(-1)),
+			"finite": 1.5,
+			"nested": bson.D{{Key: "scores", Value: bson.A{1.0, math.Inf(1), bson.M{"x": math.NaN()}}}},
+		},
+	}
+	actual, err := encodeEntry(entry)
+	if err != nil {
+		t.Fatalf("Did not expect an error, but got: %v", err)
+	}
+	expected := `[{"o":{"finite":1.5,"inf":"Infinity","nan":"NaN","negInf":"-Infinity","nested":[{"Key":"scores","Value":[1,"Infinity",{"x":"NaN"}]}]}}]`
+	if actual != expected {
+		t.Errorf("Entry mismatch:\nExpected: %s\nActual  : %s", expected, actual)
+	}
+}
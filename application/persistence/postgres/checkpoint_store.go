@@ -0,0 +1,77 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"op-log-parser/application/ports"
+)
+
+const checkpointTable = "op_log_parser_checkpoint"
+
+// checkpointStore keeps the checkpoint in a metadata table of the target
+// database, one JSON row per named pipeline. It is saved on its own
+// connection after the data was committed, not in the same transaction.
+type checkpointStore struct {
+	db   *sql.DB
+	name string
+}
+
+func NewCheckpointStore(postgresURI, name string) (ports.CheckpointStore, error) {
+	db, err := sql.Open("postgres", postgresURI)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	createTable := fmt.Sprintf(
+		"CREATE TABLE IF NOT EXISTS public.%s (name VARCHAR(255) PRIMARY KEY, checkpoint JSONB NOT NULL, updated_at TIMESTAMPTZ NOT NULL);",
+		checkpointTable)
+	if _, err := db.ExecContext(ctx, createTable); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("creating checkpoint table: %v", err)
+	}
+
+	return &checkpointStore{db: db, name: name}, nil
+}
+
+func (s *checkpointStore) Load(ctx context.Context) (*ports.Checkpoint, error) {
+	var data []byte
+	query := fmt.Sprintf("SELECT checkpoint FROM public.%s WHERE name = $1;", checkpointTable)
+	err := s.db.QueryRowContext(ctx, query, s.name).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("loading checkpoint: %v", err)
+	}
+	var checkpoint ports.Checkpoint
+	if err := json.Unmarshal(data, &checkpoint); err != nil {
+		return nil, fmt.Errorf("parsing checkpoint %s: %v", s.name, err)
+	}
+	return &checkpoint, nil
+}
+
+func (s *checkpointStore) Save(ctx context.Context, checkpoint ports.Checkpoint) error {
+	data, err := json.Marshal(checkpoint)
+	if err != nil {
+		return fmt.Errorf("encoding checkpoint: %v", err)
+	}
+
+	query := fmt.Sprintf(
+		"INSERT INTO public.%s (name, checkpoint, updated_at) VALUES ($1, $2, now()) ON CONFLICT (name) DO UPDATE SET checkpoint = EXCLUDED.checkpoint, updated_at = EXCLUDED.updated_at;",
+		checkpointTable)
+	if _, err := s.db.ExecContext(ctx, query, s.name, data); err != nil {
+		return fmt.Errorf("saving checkpoint %s: %v", s.name, err)
+	}
+	return nil
+}
+
+func (s *checkpointStore) Close() error {
+	return s.db.Close()
+}
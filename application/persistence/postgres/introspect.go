@@ -0,0 +1,126 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"op-log-parser/application/domain/services"
+
+	"github.com/lib/pq"
+)
+
+const introspectColumnsQuery = `
+SELECT c.table_schema, c.table_name, c.column_name, c.data_type, c.character_maximum_length
+FROM information_schema.columns c
+JOIN information_schema.tables t
+  ON t.table_schema = c.table_schema AND t.table_name = c.table_name
+WHERE t.table_type = 'BASE TABLE'
+  AND c.table_schema NOT IN ('pg_catalog', 'information_schema')
+  AND c.table_schema NOT LIKE 'pg_toast%'
+  AND NOT (c.table_schema = 'public' AND c.table_name = ANY($1))
+ORDER BY c.table_schema, c.table_name, c.ordinal_position;`
+
+// internalTables are the tables of the tool itself, kept in the public
+// schema and never introspected.
+var internalTables = []string{schemaStateTable, checkpointTable}
+
+const introspectSchemasQuery = `
+SELECT schema_name
+FROM information_schema.schemata
+WHERE schema_name NOT IN ('pg_catalog', 'information_schema')
+  AND schema_name NOT LIKE 'pg_toast%'
+  AND schema_name NOT LIKE 'pg_temp%';`
+
+// IntrospectSchema seeds the tracker with the schemas, tables and columns that
+// already exist in the target database, so only genuinely new columns produce
+// DDL.
+func (w *PostgresWriter) IntrospectSchema(ctx context.Context, tracker services.SchemaTracker) error {
+	schemaRows, err := w.db.QueryContext(ctx, introspectSchemasQuery)
+	if err != nil {
+		return fmt.Errorf("introspecting schemas: %v", err)
+	}
+	defer schemaRows.Close()
+
+	for schemaRows.Next() {
+		var schema string
+		if err := schemaRows.Scan(&schema); err != nil {
+			return fmt.Errorf("introspecting schemas: %v", err)
+		}
+		if err := tracker.MarkDDLGenerated(schema); err != nil {
+			return err
+		}
+	}
+	if err := schemaRows.Err(); err != nil {
+		return fmt.Errorf("introspecting schemas: %v", err)
+	}
+
+	columnRows, err := w.db.QueryContext(ctx, introspectColumnsQuery, pq.Array(internalTables))
+	if err != nil {
+		return fmt.Errorf("introspecting columns: %v", err)
+	}
+	defer columnRows.Close()
+
+	tables := make(map[string]map[string]string)
+	for columnRows.Next() {
+		var schema, table, column, dataType string
+		var maxLength *int64
+		if err := columnRows.Scan(&schema, &table, &column, &dataType, &maxLength); err != nil {
+			return fmt.Errorf("introspecting columns: %v", err)
+		}
+		namespace := fmt.Sprintf("%s.%s", schema, table)
+		if tables[namespace] == nil {
+			tables[namespace] = make(map[string]string)
+		}
+		tables[namespace][column] = normalizeSqlType(dataType, maxLength)
+	}
+	if err := columnRows.Err(); err != nil {
+		return fmt.Errorf("introspecting columns: %v", err)
+	}
+
+	for namespace, columns := range tables {
+		if err := seedTable(tracker, namespace, columns); err != nil {
+			return err
+		}
+	}
+	log.Printf("Introspected %d existing tables from PostgreSQL", len(tables))
+	return nil
+}
+
+func seedTable(tracker services.SchemaTracker, namespace string, columns map[string]string) error {
+	if !tracker.IsDDLGenerated(namespace) {
+		if err := tracker.MarkDDLGenerated(namespace); err != nil {
+			return err
+		}
+		return tracker.InitializeColumnTracker(namespace, columns)
+	}
+
+	knownColumns := tracker.GetKnownColumns(namespace)
+	newColumns := make(map[string]string)
+	for column, sqlType := range columns {
+		if !knownColumns[column] {
+			newColumns[column] = sqlType
+		}
+	}
+	if len(newColumns) == 0 {
+		return nil
+	}
+	return tracker.UpdateColumnsTracker(namespace, newColumns)
+}
+
+// normalizeSqlType maps catalog type names back to the spelling the parser
+// uses in its DDL.
+func normalizeSqlType(dataType string, maxLength *int64) string {
+	switch dataType {
+	case "character varying":
+		if maxLength != nil {
+			return fmt.Sprintf("VARCHAR(%d)", *maxLength)
+		}
+		return "VARCHAR"
+	case "double precision":
+		return "FLOAT"
+	default:
+		return strings.ToUpper(dataType)
+	}
+}
@@ -0,0 +1,51 @@
+package postgres
+
+import (
+	"reflect"
+	"testing"
+
+	"op-log-parser/application/persistence/memory"
+)
+
+func TestNormalizeSqlType(t *testing.T) {
+	length := int64(64)
+	testCases := []struct {
+		dataType  string
+		maxLength *int64
+		expected  string
+	}{
+		{dataType: "character varying", maxLength: &length, expected: "VARCHAR(64)"},
+		{dataType: "character varying", expected: "VARCHAR"},
+		{dataType: "double precision", expected: "FLOAT"},
+		{dataType: "bigint", expected: "BIGINT"},
+		{dataType: "jsonb", expected: "JSONB"},
+		{dataType: "timestamp with time zone", expected: "TIMESTAMP WITH TIME ZONE"},
+	}
+
+	for _, tc := range testCases {
+		if actual := normalizeSqlType(tc.dataType, tc.maxLength); actual != tc.expected {
+			t.Errorf("normalizeSqlType(%q): expected %q, got %q", tc.dataType, tc.expected, actual)
+		}
+	}
+}
+
+func TestSeedTable(t *testing.T) {
+	tracker := memory.NewSchemaTracker()
+	if err := seedTable(tracker, "test.student", map[string]string{"_id": "VARCHAR(255)", "age": "INTEGER"}); err != nil {
+		t.Fatalf("Did not expect an error, but got: %v", err)
+	}
+	if !tracker.IsDDLGenerated("test.student") {
+		t.Errorf("Expected the table to be marked as generated")
+	}
+
+	// A column added to the target since is merged in, tracked types are kept
+	tracker.UpdateColumnsTracker("test.student", map[string]string{"name": "VARCHAR(255)"})
+	if err := seedTable(tracker, "test.student", map[string]string{"_id": "TEXT", "age": "INTEGER", "active": "BOOLEAN"}); err != nil {
+		t.Fatalf("Did not expect an error, but got: %v", err)
+	}
+
+	expected := map[string]string{"_id": "VARCHAR(255)", "age": "INTEGER", "name": "VARCHAR(255)", "active": "BOOLEAN"}
+	if actual := tracker.GetColumnTypes("test.student"); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Column types mismatch:\nExpected: %v\nActual  : %v", expected, actual)
+	}
+}
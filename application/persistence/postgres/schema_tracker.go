@@ -0,0 +1,136 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"op-log-parser/application/domain/services"
+	"op-log-parser/application/persistence/memory"
+)
+
+const schemaStateTable = "op_log_parser_schema_state"
+
+// schemaTracker keeps the schema state in a metadata table of the target
+// database, one JSON row per namespace.
+type schemaTracker struct {
+	*memory.SchemaTracker
+	db *sql.DB
+}
+
+func NewSchemaTracker(postgresURI string) (services.SchemaTracker, error) {
+	db, err := sql.Open("postgres", postgresURI)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	state, err := loadSchemaState(ctx, db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &schemaTracker{
+		SchemaTracker: memory.NewSchemaTrackerWithState(state),
+		db:            db,
+	}, nil
+}
+
+func loadSchemaState(ctx context.Context, db *sql.DB) (map[string]*memory.NamespaceState, error) {
+	createTable := fmt.Sprintf(
+		"CREATE TABLE IF NOT EXISTS public.%s (namespace VARCHAR(255) PRIMARY KEY, state JSONB NOT NULL);",
+		schemaStateTable)
+	if _, err := db.ExecContext(ctx, createTable); err != nil {
+		return nil, fmt.Errorf("creating schema state table: %v", err)
+	}
+
+	rows, err := db.QueryContext(ctx, fmt.Sprintf("SELECT namespace, state FROM public.%s;", schemaStateTable))
+	if err != nil {
+		return nil, fmt.Errorf("loading schema state: %v", err)
+	}
+	defer rows.Close()
+
+	state := make(map[string]*memory.NamespaceState)
+	for rows.Next() {
+		var namespace string
+		var data []byte
+		if err := rows.Scan(&namespace, &data); err != nil {
+			return nil, fmt.Errorf("loading schema state: %v", err)
+		}
+		var namespaceState memory.NamespaceState
+		if err := json.Unmarshal(data, &namespaceState); err != nil {
+			return nil, fmt.Errorf("parsing schema state for %s: %v", namespace, err)
+		}
+		state[namespace] = &namespaceState
+	}
+	return state, rows.Err()
+}
+
+func (t *schemaTracker) MarkDDLGenerated(namespace string) error {
+	if err := t.SchemaTracker.MarkDDLGenerated(namespace); err != nil {
+		return err
+	}
+	return t.save(namespace)
+}
+
+func (t *schemaTracker) InitializeColumnTracker(namespace string, columns map[string]string) error {
+	if err := t.SchemaTracker.InitializeColumnTracker(namespace, columns); err != nil {
+		return err
+	}
+	return t.save(namespace)
+}
+
+func (t *schemaTracker) UpdateColumnsTracker(namespace string, newColumns map[string]string) error {
+	if err := t.SchemaTracker.UpdateColumnsTracker(namespace, newColumns); err != nil {
+		return err
+	}
+	return t.save(namespace)
+}
+
+func (t *schemaTracker) MarkColumnsNullable(namespace string, columns []string) error {
+	if state, exists := t.Namespace(namespace); exists && state.IsNullable(columns) {
+		return nil
+	}
+	if err := t.SchemaTracker.MarkColumnsNullable(namespace, columns); err != nil {
+		return err
+	}
+	return t.save(namespace)
+}
+
+func (t *schemaTracker) SetColumnNames(namespace string, names map[string]string) error {
+	if err := t.SchemaTracker.SetColumnNames(namespace, names); err != nil {
+		return err
+	}
+	return t.save(namespace)
+}
+
+func (t *schemaTracker) save(namespace string) error {
+	state, exists := t.Namespace(namespace)
+	if !exists {
+		return nil
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("encoding schema state: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	query := fmt.Sprintf(
+		"INSERT INTO public.%s (namespace, state) VALUES ($1, $2) ON CONFLICT (namespace) DO UPDATE SET state = EXCLUDED.state;",
+		schemaStateTable)
+	if _, err := t.db.ExecContext(ctx, query, namespace, data); err != nil {
+		return fmt.Errorf("saving schema state for %s: %v", namespace, err)
+	}
+	return nil
+}
+
+func (t *schemaTracker) Close() error {
+	return t.db.Close()
+}
@@ -3,16 +3,32 @@ package postgres
 import (
 	"context"
 	"database/sql"
+	"fmt"
+	"log"
 	"op-log-parser/application/ports"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	_ "github.com/lib/pq"
 )
 
+// skippedReportInterval is how often the writer logs the guarded statements
+// it skipped since the last report.
+const skippedReportInterval = time.Minute
+
 type PostgresWriter struct {
 	db     *sql.DB
 	config ports.WriterConfig
+	// stale counts the guarded statements that changed nothing because the
+	// target row already reflected a newer oplog entry, missing those whose
+	// target row did not exist.
+	stale   atomic.Int64
+	missing atomic.Int64
+
+	lastReport      time.Time
+	reportedStale   int64
+	reportedMissing int64
 }
 
 func NewWriter(config ports.WriterConfig) (ports.Writer, error) {
@@ -39,29 +55,126 @@ func NewWriter(config ports.WriterConfig) (ports.Writer, error) {
 	}, nil
 }
 
-func (w *PostgresWriter) Write(ctx context.Context, oplogs <-chan []string) <-chan error {
+func (w *PostgresWriter) Write(ctx context.Context, batches <-chan ports.Batch) <-chan error {
 	errChan := make(chan error, 1)
 
 	go func() {
 		defer close(errChan)
 
-		for oplog := range oplogs {
+		for batch := range batches {
 			select {
 			case <-ctx.Done():
 				errChan <- ctx.Err()
 				return
 			default:
-				_, err := w.db.Exec(strings.Join(oplog, ""))
-				if err != nil {
+				if err := w.apply(ctx, batch); err != nil {
 					errChan <- err
 					return
 				}
+				if batch.OnApplied != nil {
+					batch.OnApplied()
+				}
+				w.reportSkipped(false)
 			}
 		}
+		w.reportSkipped(true)
 	}()
 	return errChan
 }
 
+// execer is implemented by both *sql.DB and *sql.Tx.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// apply runs a batch, inside a database transaction when the batch is one.
+func (w *PostgresWriter) apply(ctx context.Context, batch ports.Batch) error {
+	if !batch.Transaction || len(batch.Statements) == 0 {
+		return w.execute(ctx, w.db, batch)
+	}
+
+	tx, err := w.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("beginning transaction: %v", err)
+	}
+	if err := w.execute(ctx, tx, batch); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("committing transaction: %v", err)
+	}
+	return nil
+}
+
+// execute runs the statements of batch in order. Guarded statements run on
+// their own so the rows they affect can be checked; when they change
+// nothing, their guard query tells a stale entry from a missing row.
+func (w *PostgresWriter) execute(ctx context.Context, db execer, batch ports.Batch) error {
+	var pending []string
+	for i, statement := range batch.Statements {
+		guard := batch.Guard(i)
+		if guard == "" {
+			pending = append(pending, statement)
+			continue
+		}
+		if len(pending) > 0 {
+			if _, err := db.ExecContext(ctx, strings.Join(pending, "")); err != nil {
+				return err
+			}
+			pending = nil
+		}
+		result, err := db.ExecContext(ctx, statement)
+		if err != nil {
+			return err
+		}
+		if affected, err := result.RowsAffected(); err != nil || affected > 0 {
+			continue
+		}
+		result, err = db.ExecContext(ctx, guard)
+		if err != nil {
+			return err
+		}
+		if found, err := result.RowsAffected(); err == nil && found > 0 {
+			w.stale.Add(1)
+		} else {
+			w.missing.Add(1)
+		}
+	}
+	if len(pending) > 0 {
+		if _, err := db.ExecContext(ctx, strings.Join(pending, "")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reportSkipped logs the guarded statements skipped since the last report,
+// at most once per skippedReportInterval unless final is set.
+func (w *PostgresWriter) reportSkipped(final bool) {
+	now := time.Now()
+	if w.lastReport.IsZero() {
+		w.lastReport = now
+	}
+	if !final && now.Sub(w.lastReport) < skippedReportInterval {
+		return
+	}
+	w.lastReport = now
+	stale, missing := w.stale.Load(), w.missing.Load()
+	if stale == w.reportedStale && missing == w.reportedMissing {
+		return
+	}
+	log.Printf("Skipped %d stale statements older than the target rows and %d whose target row is missing (%d and %d in total)",
+		stale-w.reportedStale, missing-w.reportedMissing, stale, missing)
+	w.reportedStale, w.reportedMissing = stale, missing
+}
+
+// SkippedStatements returns the number of guarded statements skipped so far
+// because the target row was newer, and because it was missing.
+func (w *PostgresWriter) SkippedStatements() (stale, missing int64) {
+	return w.stale.Load(), w.missing.Load()
+}
+
 func (w *PostgresWriter) Close() error {
 	return w.db.Close()
 }
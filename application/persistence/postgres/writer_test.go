@@ -0,0 +1,63 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"reflect"
+	"testing"
+
+	"op-log-parser/application/ports"
+)
+
+// fakeExecer records the executed queries and reports the rows affected
+// configured for them, none by default.
+type fakeExecer struct {
+	affected map[string]int64
+	executed []string
+}
+
+func (e *fakeExecer) ExecContext(_ context.Context, query string, _ ...any) (sql.Result, error) {
+	e.executed = append(e.executed, query)
+	return driver.RowsAffected(e.affected[query]), nil
+}
+
+func TestExecuteCountsSkippedStatements(t *testing.T) {
+	var batch ports.Batch
+	batch.Add([]string{"CREATE TABLE t (_id TEXT);"}, nil)
+	batch.Add(
+		[]string{"UPDATE t SET a = 1 WHERE _id = '1' AND guard;", "UPDATE t SET a = 2 WHERE _id = '2' AND guard;"},
+		[]string{"SELECT 1 FROM t WHERE _id = '1';", "SELECT 1 FROM t WHERE _id = '2';"},
+	)
+	batch.Add([]string{"DELETE FROM t WHERE _id = '3' AND guard;", "INSERT INTO t VALUES ('4');"}, []string{"SELECT 1 FROM t WHERE _id = '3';"})
+	batch.Add([]string{"UPDATE t SET a = 5 WHERE _id = '5' AND guard;"}, []string{"SELECT 1 FROM t WHERE _id = '5';"})
+
+	db := &fakeExecer{affected: map[string]int64{
+		// _id 1 is newer in the target, _id 2 is missing, _id 3 is missing
+		// too and _id 5 is applied
+		"SELECT 1 FROM t WHERE _id = '1';":              1,
+		"UPDATE t SET a = 5 WHERE _id = '5' AND guard;": 1,
+	}}
+	writer := &PostgresWriter{}
+	if err := writer.execute(context.Background(), db, batch); err != nil {
+		t.Fatalf("Did not expect an error, but got: %v", err)
+	}
+
+	expected := []string{
+		"CREATE TABLE t (_id TEXT);",
+		"UPDATE t SET a = 1 WHERE _id = '1' AND guard;",
+		"SELECT 1 FROM t WHERE _id = '1';",
+		"UPDATE t SET a = 2 WHERE _id = '2' AND guard;",
+		"SELECT 1 FROM t WHERE _id = '2';",
+		"DELETE FROM t WHERE _id = '3' AND guard;",
+		"SELECT 1 FROM t WHERE _id = '3';",
+		"INSERT INTO t VALUES ('4');",
+		"UPDATE t SET a = 5 WHERE _id = '5' AND guard;",
+	}
+	if !reflect.DeepEqual(db.executed, expected) {
+		t.Errorf("Executed mismatch:\nExpected: %q\nActual  : %q", expected, db.executed)
+	}
+	if stale, missing := writer.SkippedStatements(); stale != 1 || missing != 2 {
+		t.Errorf("Expected 1 stale and 2 missing, got %d and %d", stale, missing)
+	}
+}
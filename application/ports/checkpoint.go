@@ -0,0 +1,30 @@
+package ports
+
+import (
+	"context"
+
+	"op-log-parser/application/domain/models"
+)
+
+// Checkpoint is the position of the last oplog entry whose statements the
+// writer applied. Change stream positions also carry their resume token.
+// During an initial snapshot Timestamp is the position tailing starts from
+// and Snapshot the progress of each collection.
+type Checkpoint struct {
+	Timestamp   models.Timestamp                   `json:"ts"`
+	ResumeToken map[string]any                     `json:"resumeToken,omitempty"`
+	Snapshot    map[string]models.SnapshotPosition `json:"snapshot,omitempty"`
+}
+
+// CheckpointStore persists the checkpoint so a restarted run resumes after
+// the last applied entry. Checkpoints are saved after the writer applied the
+// statements, not atomically with them, so delivery is at-least-once: the
+// entries applied after the last saved checkpoint are applied again after a
+// crash.
+type CheckpointStore interface {
+	// Load returns the stored checkpoint, or nil when there is none yet.
+	Load(ctx context.Context) (*Checkpoint, error)
+	Save(ctx context.Context, checkpoint Checkpoint) error
+
+	Close() error
+}
@@ -2,8 +2,15 @@ package ports
 
 import (
 	"context"
+	"errors"
+
+	"op-log-parser/application/domain/models"
 )
 
+// ErrOplogGap is returned by readers whose resume point is no longer in the
+// oplog, so changes were missed and the target needs a new snapshot.
+var ErrOplogGap = errors.New("oplog gap")
+
 type Reader interface {
 	Read(ctx context.Context) (<-chan string, <-chan error)
 
@@ -13,4 +20,41 @@ type Reader interface {
 type ReaderConfig struct {
 	FilePath string
 	MongoURI string
+	// Database and Collection scope a change stream. Both empty watch the
+	// whole cluster, only Database watches one database.
+	Database   string
+	Collection string
+	// FullDocument and FullDocumentBeforeChange are the change stream
+	// options of the same name, e.g. "updateLookup" and "whenAvailable".
+	// Updates of nested fields need updateLookup. Without a pre-image a
+	// replace cannot unset the fields the new document dropped, they keep
+	// their last value.
+	FullDocument             string
+	FullDocumentBeforeChange string
+	// ResumeFrom, when set, skips the entries up to and including the
+	// checkpoint.
+	ResumeFrom *Checkpoint
+	// Since and Until bound the entries read to Since <= ts < Until. Zero
+	// values leave that side unbounded, reading stops at Until.
+	Since models.Timestamp
+	Until models.Timestamp
+	// Snapshot copies the existing documents of the collections matching
+	// SnapshotNamespaces (path.Match patterns on "db.collection", all user
+	// collections when empty) before tailing, SnapshotParallelism
+	// collections at a time. The copy is not a point-in-time snapshot:
+	// documents changed during the scan are copied as the scan finds them,
+	// and converge once tailing replays the oplog from where it started.
+	Snapshot            bool
+	SnapshotNamespaces  []string
+	SnapshotParallelism int
+}
+
+// InWindow reports whether ts is within the Since and Until bounds.
+func (c ReaderConfig) InWindow(ts models.Timestamp) bool {
+	return !ts.Before(c.Since) && !c.PastWindow(ts)
+}
+
+// PastWindow reports whether ts is at or after the Until bound.
+func (c ReaderConfig) PastWindow(ts models.Timestamp) bool {
+	return !c.Until.IsZero() && !ts.Before(c.Until)
 }
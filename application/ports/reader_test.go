@@ -0,0 +1,39 @@
+package ports
+
+import (
+	"testing"
+
+	"op-log-parser/application/domain/models"
+)
+
+func TestReaderConfigWindow(t *testing.T) {
+	bounded := ReaderConfig{Since: models.Timestamp{T: 10, I: 2}, Until: models.Timestamp{T: 20, I: 2}}
+	testCases := []struct {
+		name         string
+		config       ReaderConfig
+		ts           models.Timestamp
+		expectedIn   bool
+		expectedPast bool
+	}{
+		{name: "before Since", config: bounded, ts: models.Timestamp{T: 10, I: 1}},
+		{name: "Since is inclusive", config: bounded, ts: models.Timestamp{T: 10, I: 2}, expectedIn: true},
+		{name: "within the window", config: bounded, ts: models.Timestamp{T: 15}, expectedIn: true},
+		{name: "right before Until", config: bounded, ts: models.Timestamp{T: 20, I: 1}, expectedIn: true},
+		{name: "Until is exclusive", config: bounded, ts: models.Timestamp{T: 20, I: 2}, expectedPast: true},
+		{name: "after Until", config: bounded, ts: models.Timestamp{T: 21}, expectedPast: true},
+		{name: "unbounded", config: ReaderConfig{}, ts: models.Timestamp{T: 4294967295, I: 4294967295}, expectedIn: true},
+		{name: "only Since", config: ReaderConfig{Since: models.Timestamp{T: 10}}, ts: models.Timestamp{T: 4294967295}, expectedIn: true},
+		{name: "only Until", config: ReaderConfig{Until: models.Timestamp{T: 10}}, ts: models.Timestamp{}, expectedIn: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if actual := tc.config.InWindow(tc.ts); actual != tc.expectedIn {
+				t.Errorf("InWindow(%+v): expected %v, got %v", tc.ts, tc.expectedIn, actual)
+			}
+			if actual := tc.config.PastWindow(tc.ts); actual != tc.expectedPast {
+				t.Errorf("PastWindow(%+v): expected %v, got %v", tc.ts, tc.expectedPast, actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,13 @@
+package ports
+
+import (
+	"context"
+
+	"op-log-parser/application/domain/services"
+)
+
+// SchemaIntrospector is implemented by writers that can report the schema
+// already present in their target, so the parser does not recreate it.
+type SchemaIntrospector interface {
+	IntrospectSchema(ctx context.Context, tracker services.SchemaTracker) error
+}
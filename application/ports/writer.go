@@ -5,12 +5,48 @@ import (
 )
 
 type Writer interface {
-	Write(ctx context.Context, statements <-chan []string) <-chan error
+	Write(ctx context.Context, batches <-chan Batch) <-chan error
 
 	Close() error
 }
 
+// Batch holds the statements generated for one oplog entry, or for all the
+// entries of a session transaction. Transaction batches must be applied
+// atomically.
+type Batch struct {
+	Statements []string
+	// Guards holds, per statement, the query selecting the target row of a
+	// statement that only applies when the row was last changed by an older
+	// oplog entry. It is empty when no statement is guarded.
+	Guards      []string
+	Transaction bool
+	// OnApplied, when set, is called by the writer once the statements are
+	// applied. Batches without statements still carry it.
+	OnApplied func()
+}
+
+// Add appends statements with their guards to the batch.
+func (b *Batch) Add(statements, guards []string) {
+	if len(guards) > 0 || len(b.Guards) > 0 {
+		b.Guards = append(b.Guards, make([]string, len(b.Statements)-len(b.Guards))...)
+		b.Guards = append(b.Guards, guards...)
+		b.Guards = append(b.Guards, make([]string, len(statements)-len(guards))...)
+	}
+	b.Statements = append(b.Statements, statements...)
+}
+
+// Guard returns the guard query of the statement at index i, if any.
+func (b Batch) Guard(i int) string {
+	if i < len(b.Guards) {
+		return b.Guards[i]
+	}
+	return ""
+}
+
 type WriterConfig struct {
 	FilePath    string
 	PostgresURI string
+	// Append appends to FilePath instead of truncating it, for runs that
+	// resume from a checkpoint.
+	Append bool
 }
@@ -2,15 +2,42 @@ package services
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"log"
-	"op-log-parser/application/ports"
+	"maps"
+	"op-log-parser/application/domain/models"
 	"op-log-parser/application/domain/services"
+	"op-log-parser/application/ports"
+	"slices"
+	"time"
+)
+
+// snapshotCheckpointEvery and snapshotCheckpointInterval throttle the
+// checkpoints of snapshot inserts: one is saved every that many inserts or
+// that much time, whichever comes first, and at the end of every collection.
+const (
+	snapshotCheckpointEvery    = 1000
+	snapshotCheckpointInterval = 10 * time.Second
 )
 
+// groupIdleTimeout is how long a group of session entries waits for more
+// entries of the same session before it is written. Such groups are
+// retryable writes, whose entries are logged together; transactions are
+// logged as applyOps and always wait for their commit.
+const groupIdleTimeout = 100 * time.Millisecond
+
 type OpLogProcessor struct {
-	reader ports.Reader
-	writer ports.Writer
-	parser services.ParserService
+	reader      ports.Reader
+	writer      ports.Writer
+	parser      services.ParserService
+	checkpoints ports.CheckpointStore
+	// snapshot is the applied progress of an unfinished initial snapshot.
+	snapshot map[string]models.SnapshotPosition
+	// snapshotUnsaved counts the snapshot inserts applied since the
+	// checkpoint saved at snapshotSaved.
+	snapshotUnsaved int
+	snapshotSaved   time.Time
 }
 
 func NewOpLogProcessor(reader ports.Reader, writer ports.Writer, parser services.ParserService) *OpLogProcessor {
@@ -21,34 +48,40 @@ func NewOpLogProcessor(reader ports.Reader, writer ports.Writer, parser services
 	}
 }
 
+// NewOpLogProcessorWithCheckpoints also saves the position of every entry in
+// checkpoints once the writer applied its statements. resumeFrom is the
+// checkpoint the reader resumes from, if any.
+func NewOpLogProcessorWithCheckpoints(reader ports.Reader, writer ports.Writer, parser services.ParserService, checkpoints ports.CheckpointStore, resumeFrom *ports.Checkpoint) *OpLogProcessor {
+	processor := &OpLogProcessor{
+		reader:      reader,
+		writer:      writer,
+		parser:      parser,
+		checkpoints: checkpoints,
+	}
+	if resumeFrom != nil {
+		processor.snapshot = resumeFrom.Snapshot
+	}
+	return processor
+}
+
 func (p *OpLogProcessor) Process(ctx context.Context) error {
 	oplogChan, errChan := p.reader.Read(ctx)
-	processedChan := make(chan []string)
+	batchChan := make(chan ports.Batch)
 
 	go func() {
-		defer close(processedChan)
-		for oplog := range oplogChan {
-			select {
-			case <-ctx.Done():
-				return
-			default:
-				processed, err := p.parser.Parse(oplog)
-				if err != nil {
-					log.Printf("Error processing oplog: %v\n", err)
-					continue
-				}
-				processedChan <- processed
-			}
-		}
+		defer close(batchChan)
+		p.batch(ctx, oplogChan, batchChan)
 	}()
 
-	writeErrChan := p.writer.Write(ctx, processedChan)
+	writeErrChan := p.writer.Write(ctx, batchChan)
 
 	for {
 		select {
 		case err, ok := <-errChan:
 			if !ok {
 				errChan = nil
+			} else if errors.Is(err, ports.ErrOplogGap) {
+				return err
 			} else if err != nil {
 				log.Printf("Reader error: %v\n", err)
 			}
@@ -67,3 +100,229 @@ func (p *OpLogProcessor) Process(ctx context.Context) error {
 		}
 	}
 }
+
+// batch parses the oplog entries and groups consecutive entries of the same
+// session (lsid and txnNumber), such as the inserts of one retryable
+// insertMany, into one transaction batch. A group is written once an entry
+// of another session or the end of the input shows it is complete, or once
+// no entry arrived for groupIdleTimeout, so a live tail does not hold the
+// last write. applyOps entries of a transaction continued by later entries
+// are held until the entry that commits it and dropped when it is aborted;
+// no checkpoint is saved while one is held. Groups and held transactions are
+// only parsed once complete, a group or transaction with an entry that fails
+// to parse is dropped as a whole, along with its schema changes. Other
+// entries are written on their own.
+func (p *OpLogProcessor) batch(ctx context.Context, oplogChan <-chan string, batchChan chan<- ports.Batch) {
+	var group []models.OpLog
+	var groupKey string
+	var idle <-chan time.Time
+	open := make(map[string][]models.OpLog)
+
+	send := func(batch ports.Batch) bool {
+		if len(batch.Statements) == 0 && batch.OnApplied == nil {
+			return true
+		}
+		select {
+		case batchChan <- batch:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+	checkpoint := func(opLog models.OpLog) func() {
+		if len(open) > 0 {
+			return nil
+		}
+		return p.checkpoint(ctx, opLog)
+	}
+	flush := func() bool {
+		opLogs, key := group, groupKey
+		group, groupKey, idle = nil, "", nil
+		if len(opLogs) == 0 {
+			return true
+		}
+		batch, err := p.process(opLogs...)
+		if err != nil {
+			log.Printf("Dropping transaction %s: %v\n", key, err)
+			return true
+		}
+		batch.Transaction = true
+		batch.OnApplied = chain(batch.OnApplied, checkpoint(opLogs[len(opLogs)-1]))
+		return send(batch)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-idle:
+			if !flush() {
+				return
+			}
+		case oplog, ok := <-oplogChan:
+			if !ok {
+				flush()
+				return
+			}
+
+			var opLogs []models.OpLog
+			if err := json.Unmarshal([]byte(oplog), &opLogs); err != nil {
+				log.Printf("Error processing oplog: %v\n", err)
+				continue
+			}
+			for _, opLog := range opLogs {
+				key := transactionKey(opLog)
+				step := transactionStepOf(opLog)
+				if (step != stepNone || key != groupKey) && !flush() {
+					return
+				}
+
+				if step != stepNone {
+					batch, ok := p.transaction(opLog, step, key, open)
+					if ok {
+						batch.OnApplied = chain(batch.OnApplied, checkpoint(opLog))
+						if !send(batch) {
+							return
+						}
+					}
+					continue
+				}
+
+				if key != "" {
+					group = append(group, opLog)
+					groupKey = key
+					idle = time.After(groupIdleTimeout)
+					continue
+				}
+
+				// A failed entry does not move the checkpoint
+				batch, err := p.process(opLog)
+				if err != nil {
+					log.Printf("Error processing oplog: %v\n", err)
+					continue
+				}
+				batch.OnApplied = chain(batch.OnApplied, checkpoint(opLog))
+				if !send(batch) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// transaction handles an entry of an applyOps transaction, holding the
+// entries in open until the transaction is complete. It returns the batch of
+// the transaction once the entry completes it, and false while the
+// transaction stays open or when it is dropped.
+func (p *OpLogProcessor) transaction(opLog models.OpLog, step transactionStep, key string, open map[string][]models.OpLog) (ports.Batch, bool) {
+	opLogs, ok := open[key]
+	switch step {
+	case stepAbort:
+		delete(open, key)
+		if ok {
+			log.Printf("Dropping aborted transaction %s\n", key)
+		}
+		return ports.Batch{}, true
+	case stepCommit:
+		delete(open, key)
+		if !ok {
+			log.Printf("Ignoring commit of transaction %s, its entries were not read\n", key)
+			return ports.Batch{}, true
+		}
+	case stepPartial:
+		open[key] = append(opLogs, opLog)
+		return ports.Batch{}, false
+	default:
+		delete(open, key)
+		opLogs = append(opLogs, opLog)
+	}
+
+	batch, err := p.process(opLogs...)
+	if err != nil {
+		log.Printf("Dropping transaction %s: %v\n", key, err)
+		return ports.Batch{}, false
+	}
+	batch.Transaction = true
+	return batch, true
+}
+
+// checkpoint returns the callback that saves the position of opLog, or nil
+// without a checkpoint store or a position.
+func (p *OpLogProcessor) checkpoint(ctx context.Context, opLog models.OpLog) func() {
+	if p.checkpoints == nil || (opLog.Timestamp.IsZero() && opLog.ResumeToken == nil) {
+		return nil
+	}
+	checkpoint := ports.Checkpoint{Timestamp: opLog.Timestamp, ResumeToken: opLog.ResumeToken}
+	return func() {
+		// Snapshot inserts add to the progress of the snapshot, the first
+		// tailed entry completes it
+		if opLog.Snapshot != nil {
+			if p.snapshot == nil {
+				p.snapshot = make(map[string]models.SnapshotPosition)
+			}
+			p.snapshot[opLog.Snapshot.Namespace] = *opLog.Snapshot
+			p.snapshotUnsaved++
+			if !opLog.Snapshot.Done && p.snapshotUnsaved < snapshotCheckpointEvery &&
+				time.Since(p.snapshotSaved) < snapshotCheckpointInterval {
+				return
+			}
+			p.snapshotUnsaved, p.snapshotSaved = 0, time.Now()
+			checkpoint.Snapshot = maps.Clone(p.snapshot)
+		} else {
+			p.snapshot = nil
+		}
+		if err := p.checkpoints.Save(ctx, checkpoint); err != nil {
+			log.Printf("Error saving checkpoint: %v\n", err)
+		}
+	}
+}
+
+// process returns the batch for oplog entries applied as one unit, a
+// transaction for several operations, e.g. those of applyOps commands,
+// which must be applied atomically. It fails when any of the operations
+// fails, dropping the schema changes of all of them. No-op entries have no
+// statements. OnApplied of the batch saves the schema changes.
+func (p *OpLogProcessor) process(opLogs ...models.OpLog) (ports.Batch, error) {
+	var operations []models.OpLog
+	for _, opLog := range opLogs {
+		expanded, err := expandApplyOps(opLog)
+		if err != nil {
+			return ports.Batch{}, err
+		}
+		operations = append(operations, expanded...)
+	}
+
+	batch := ports.Batch{Transaction: len(operations) > 1}
+	for _, entry := range operations {
+		if entry.Operation == noopOperation {
+			continue
+		}
+		statements, guards, err := p.parser.ProcessOpLog(entry)
+		if err != nil {
+			p.parser.DiscardSchema()
+			return ports.Batch{}, err
+		}
+		batch.Add(statements, guards)
+	}
+	commit := p.parser.StageSchema()
+	batch.OnApplied = func() {
+		if err := commit(); err != nil {
+			log.Printf("Error saving schema state: %v\n", err)
+		}
+	}
+	return batch, nil
+}
+
+// chain returns a function calling the non-nil fns in order, or nil when
+// there are none.
+func chain(fns ...func()) func() {
+	fns = slices.DeleteFunc(fns, func(fn func()) bool { return fn == nil })
+	if len(fns) == 0 {
+		return nil
+	}
+	return func() {
+		for _, fn := range fns {
+			fn()
+		}
+	}
+}
@@ -0,0 +1,363 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"op-log-parser/application/domain/models"
+	"op-log-parser/application/parsers"
+	"op-log-parser/application/persistence/memory"
+	"op-log-parser/application/ports"
+)
+
+// fakeParser renders every entry as one statement naming its operation and
+// _id, and fails entries of the test.invalid namespace.
+type fakeParser struct{}
+
+func (fakeParser) Parse(string) ([]string, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+
+func (fakeParser) ProcessOpLog(opLog models.OpLog) ([]string, []string, error) {
+	if opLog.Namespace == "test.invalid" {
+		return nil, nil, fmt.Errorf("invalid entry")
+	}
+	return []string{fmt.Sprintf("%s %v;", opLog.Operation, opLog.Data["_id"])}, nil, nil
+}
+
+func (fakeParser) StageSchema() func() error {
+	return func() error { return nil }
+}
+
+func (fakeParser) DiscardSchema() {}
+
+func mustParseTime(value string) time.Time {
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		panic(err)
+	}
+	return parsed
+}
+
+// session renders the lsid and txnNumber of a session transaction.
+func session(txnNumber int) string {
+	return fmt.Sprintf(`"lsid": {"id": {"$binary": {"base64": "AAAAAAAAAAAAAAAAAAAAAA==", "subType": "04"}}}, "txnNumber": %d`, txnNumber)
+}
+
+func TestBatch(t *testing.T) {
+	testCases := []struct {
+		name     string
+		entries  []string
+		expected []ports.Batch
+	}{
+		{
+			name: "entries outside transactions are written on their own",
+			entries: []string{
+				`{"op": "i", "ns": "test.student", "o": {"_id": "1"}}`,
+				`{"op": "d", "ns": "test.student", "o": {"_id": "1"}}`,
+			},
+			expected: []ports.Batch{
+				{Statements: []string{"i 1;"}},
+				{Statements: []string{"d 1;"}},
+			},
+		},
+		{
+			name: "consecutive entries of a session transaction are grouped",
+			entries: []string{
+				`{"op": "i", "ns": "test.student", "o": {"_id": "1"}, ` + session(1) + `}`,
+				`{"op": "i", "ns": "test.student", "o": {"_id": "2"}, ` + session(1) + `}`,
+				`{"op": "i", "ns": "test.student", "o": {"_id": "3"}, ` + session(2) + `}`,
+				`{"op": "i", "ns": "test.student", "o": {"_id": "4"}}`,
+			},
+			expected: []ports.Batch{
+				{Statements: []string{"i 1;", "i 2;"}, Transaction: true},
+				{Statements: []string{"i 3;"}, Transaction: true},
+				{Statements: []string{"i 4;"}},
+			},
+		},
+		{
+			name: "a session group with a failing entry is dropped",
+			entries: []string{
+				`{"op": "i", "ns": "test.student", "o": {"_id": "1"}, ` + session(1) + `}`,
+				`{"op": "i", "ns": "test.invalid", "o": {"_id": "2"}, ` + session(1) + `}`,
+				`{"op": "i", "ns": "test.student", "o": {"_id": "3"}}`,
+			},
+			expected: []ports.Batch{
+				{Statements: []string{"i 3;"}},
+			},
+		},
+		{
+			name: "applyOps is a transaction",
+			entries: []string{
+				`{"op": "c", "ns": "admin.$cmd", "o": {"applyOps": [{"op": "i", "ns": "test.student", "o": {"_id": "1"}}, {"op": "i", "ns": "test.student", "o": {"_id": "2"}}]}, ` + session(1) + `}`,
+			},
+			expected: []ports.Batch{
+				{Statements: []string{"i 1;", "i 2;"}, Transaction: true},
+			},
+		},
+		{
+			name: "applyOps with a failing operation is dropped",
+			entries: []string{
+				`{"op": "c", "ns": "admin.$cmd", "o": {"applyOps": [{"op": "i", "ns": "test.student", "o": {"_id": "1"}}, {"op": "i", "ns": "test.invalid", "o": {"_id": "2"}}]}, ` + session(1) + `}`,
+				`{"op": "i", "ns": "test.student", "o": {"_id": "3"}}`,
+			},
+			expected: []ports.Batch{
+				{Statements: []string{"i 3;"}},
+			},
+		},
+		{
+			name: "partial applyOps are held until the last one",
+			entries: []string{
+				`{"op": "c", "ns": "admin.$cmd", "o": {"applyOps": [{"op": "i", "ns": "test.student", "o": {"_id": "1"}}], "partialTxn": true}, ` + session(1) + `}`,
+				`{"op": "i", "ns": "test.student", "o": {"_id": "2"}}`,
+				`{"op": "c", "ns": "admin.$cmd", "o": {"applyOps": [{"op": "i", "ns": "test.student", "o": {"_id": "3"}}]}, ` + session(1) + `}`,
+			},
+			expected: []ports.Batch{
+				{Statements: []string{"i 2;"}},
+				{Statements: []string{"i 1;", "i 3;"}, Transaction: true},
+			},
+		},
+		{
+			name: "prepared transactions are applied at commit",
+			entries: []string{
+				`{"op": "c", "ns": "admin.$cmd", "o": {"applyOps": [{"op": "i", "ns": "test.student", "o": {"_id": "1"}}], "prepare": true}, ` + session(1) + `}`,
+				`{"op": "i", "ns": "test.student", "o": {"_id": "2"}}`,
+				`{"op": "c", "ns": "admin.$cmd", "o": {"commitTransaction": 1}, ` + session(1) + `}`,
+			},
+			expected: []ports.Batch{
+				{Statements: []string{"i 2;"}},
+				{Statements: []string{"i 1;"}, Transaction: true},
+			},
+		},
+		{
+			name: "aborted prepared transactions are dropped",
+			entries: []string{
+				`{"op": "c", "ns": "admin.$cmd", "o": {"applyOps": [{"op": "i", "ns": "test.student", "o": {"_id": "1"}}], "prepare": true}, ` + session(1) + `}`,
+				`{"op": "c", "ns": "admin.$cmd", "o": {"abortTransaction": 1}, ` + session(1) + `}`,
+				`{"op": "i", "ns": "test.student", "o": {"_id": "2"}}`,
+			},
+			expected: []ports.Batch{
+				{Statements: []string{"i 2;"}},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			actual := runBatch(t, &OpLogProcessor{parser: fakeParser{}}, tc.entries)
+			for i := range actual {
+				actual[i].OnApplied = nil
+			}
+			if !reflect.DeepEqual(actual, tc.expected) {
+				t.Errorf("Batch mismatch:\nExpected: %+v\nActual  : %+v", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestBatchFlushesIdleGroups(t *testing.T) {
+	oplogChan := make(chan string, 1)
+	batchChan := make(chan ports.Batch)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go (&OpLogProcessor{parser: fakeParser{}}).batch(ctx, oplogChan, batchChan)
+
+	oplogChan <- `[{"op": "i", "ns": "test.student", "o": {"_id": "1"}, ` + session(1) + `}]`
+	select {
+	case batch := <-batchChan:
+		if expected := []string{"i 1;"}; !reflect.DeepEqual(batch.Statements, expected) {
+			t.Errorf("Expected statements %v, got %v", expected, batch.Statements)
+		}
+	case <-time.After(10 * groupIdleTimeout):
+		t.Fatalf("Expected the group to be written once idle")
+	}
+}
+
+func TestBatchDropsSchemaChangesOfDroppedGroups(t *testing.T) {
+	create := []string{
+		"CREATE SCHEMA test;",
+		"CREATE TABLE test.student (_id VARCHAR(255) PRIMARY KEY);",
+		"INSERT INTO test.student (_id) VALUES ('3');",
+	}
+	testCases := []struct {
+		name    string
+		entries []string
+	}{
+		{
+			name: "session group with a failing entry",
+			entries: []string{
+				`{"op": "i", "ns": "test.student", "o": {"_id": "1"}, ` + session(1) + `}`,
+				`{"op": "i", "ns": "test.student", "o": {"address": {"city": "x"}}, ` + session(1) + `}`,
+			},
+		},
+		{
+			name: "applyOps with a failing operation",
+			entries: []string{
+				`{"op": "c", "ns": "admin.$cmd", "o": {"applyOps": [{"op": "i", "ns": "test.student", "o": {"_id": "1"}}, {"op": "i", "ns": "test.student", "o": {"address": {"city": "x"}}}]}}`,
+			},
+		},
+		{
+			name: "aborted prepared transaction",
+			entries: []string{
+				`{"op": "c", "ns": "admin.$cmd", "o": {"applyOps": [{"op": "i", "ns": "test.student", "o": {"_id": "1"}}], "prepare": true}, ` + session(1) + `}`,
+				`{"op": "c", "ns": "admin.$cmd", "o": {"abortTransaction": 1}, ` + session(1) + `}`,
+			},
+		},
+		{
+			name: "prepared transaction never committed",
+			entries: []string{
+				`{"op": "c", "ns": "admin.$cmd", "o": {"applyOps": [{"op": "i", "ns": "test.student", "o": {"_id": "1"}}], "prepare": true}, ` + session(1) + `}`,
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			parser := parsers.NewParser(func() string { return "uuid" }, memory.NewSchemaTracker())
+			entries := append(tc.entries, `{"op": "i", "ns": "test.student", "o": {"_id": "3"}}`)
+			batches := runBatch(t, &OpLogProcessor{parser: parser}, entries)
+			if len(batches) == 0 {
+				t.Fatalf("Expected a batch for the last entry")
+			}
+			if actual := batches[len(batches)-1].Statements; !reflect.DeepEqual(actual, create) {
+				t.Errorf("SQL mismatch:\nExpected: %s\nActual  : %s", create, actual)
+			}
+		})
+	}
+}
+
+// memoryCheckpoints keeps the saved checkpoints in order.
+type memoryCheckpoints struct {
+	saved []ports.Checkpoint
+}
+
+func (c *memoryCheckpoints) Load(context.Context) (*ports.Checkpoint, error) {
+	return nil, nil
+}
+
+func (c *memoryCheckpoints) Save(_ context.Context, checkpoint ports.Checkpoint) error {
+	c.saved = append(c.saved, checkpoint)
+	return nil
+}
+
+func (c *memoryCheckpoints) Close() error {
+	return nil
+}
+
+func TestBatchHoldsCheckpointsWhileATransactionIsOpen(t *testing.T) {
+	checkpoints := &memoryCheckpoints{}
+	processor := &OpLogProcessor{parser: fakeParser{}, checkpoints: checkpoints}
+	batches := runBatch(t, processor, []string{
+		`{"op": "i", "ns": "test.student", "o": {"_id": "1"}, "ts": {"T": 1, "I": 1}}`,
+		`{"op": "c", "ns": "admin.$cmd", "o": {"applyOps": [{"op": "i", "ns": "test.student", "o": {"_id": "2"}}], "prepare": true}, "ts": {"T": 2, "I": 1}, ` + session(1) + `}`,
+		`{"op": "i", "ns": "test.student", "o": {"_id": "3"}, "ts": {"T": 3, "I": 1}}`,
+		`{"op": "c", "ns": "admin.$cmd", "o": {"commitTransaction": 1}, "ts": {"T": 4, "I": 1}, ` + session(1) + `}`,
+	})
+	for _, batch := range batches {
+		if batch.OnApplied != nil {
+			batch.OnApplied()
+		}
+	}
+
+	var saved []string
+	for _, checkpoint := range checkpoints.saved {
+		saved = append(saved, fmt.Sprintf("%d:%d", checkpoint.Timestamp.T, checkpoint.Timestamp.I))
+	}
+	if expected := []string{"1:1", "4:1"}; !reflect.DeepEqual(saved, expected) {
+		t.Errorf("Expected checkpoints %v, got %v", expected, saved)
+	}
+}
+
+func TestBatchSkipsCheckpointsOfFailedEntries(t *testing.T) {
+	checkpoints := &memoryCheckpoints{}
+	processor := NewOpLogProcessorWithCheckpoints(nil, nil, fakeParser{}, checkpoints, &ports.Checkpoint{
+		Snapshot: map[string]models.SnapshotPosition{"test.student": {Namespace: "test.student"}},
+	})
+	batches := runBatch(t, processor, []string{
+		`{"op": "i", "ns": "test.student", "o": {"_id": "1"}, "ts": {"T": 1, "I": 1}}`,
+		`{"op": "i", "ns": "test.invalid", "o": {"_id": "2"}, "ts": {"T": 2, "I": 1}}`,
+	})
+	if len(batches) != 1 {
+		t.Fatalf("Expected 1 batch, got %d", len(batches))
+	}
+	batches[0].OnApplied()
+
+	if len(checkpoints.saved) != 1 || checkpoints.saved[0].Timestamp != (models.Timestamp{T: 1, I: 1}) {
+		t.Errorf("Expected only the checkpoint 1:1, got %+v", checkpoints.saved)
+	}
+	if processor.snapshot != nil {
+		t.Errorf("Expected the resumed snapshot progress to be completed by a tailed entry, got %v", processor.snapshot)
+	}
+}
+
+func TestBatchThrottlesSnapshotCheckpoints(t *testing.T) {
+	checkpoints := &memoryCheckpoints{}
+	processor := &OpLogProcessor{parser: fakeParser{}, checkpoints: checkpoints}
+	var entries []string
+	for i := 1; i <= snapshotCheckpointEvery+2; i++ {
+		done := i == snapshotCheckpointEvery+2
+		entries = append(entries, fmt.Sprintf(`{"op": "i", "ns": "test.student", "o": {"_id": "%d"}, "ts": {"T": 1, "I": 1}, "snapshot": {"ns": "test.student", "lastId": {"_id": "%d"}, "done": %v}}`, i, i, done))
+	}
+	entries = append(entries,
+		`{"op": "n", "ns": "test.empty", "o": {}, "ts": {"T": 1, "I": 1}, "snapshot": {"ns": "test.empty", "done": true}}`,
+		`{"op": "i", "ns": "test.student", "o": {"_id": "x"}, "ts": {"T": 2, "I": 1}}`,
+	)
+	batches := runBatch(t, processor, entries)
+	if len(batches) != len(entries) {
+		t.Fatalf("Expected %d batches, got %d", len(entries), len(batches))
+	}
+	if marker := batches[len(batches)-2]; len(marker.Statements) != 0 {
+		t.Errorf("Expected no statements for the no-op entry, got %v", marker.Statements)
+	}
+	for _, batch := range batches {
+		batch.OnApplied()
+	}
+
+	var saved []string
+	for _, checkpoint := range checkpoints.saved {
+		var positions []string
+		for _, namespace := range []string{"test.empty", "test.student"} {
+			if position, ok := checkpoint.Snapshot[namespace]; ok {
+				positions = append(positions, fmt.Sprintf("%s %s %v", namespace, position.LastID, position.Done))
+			}
+		}
+		saved = append(saved, strings.Join(positions, ", "))
+	}
+	expected := []string{
+		`test.student {"_id": "1"} false`,
+		fmt.Sprintf(`test.student {"_id": "%d"} false`, snapshotCheckpointEvery+1),
+		fmt.Sprintf(`test.student {"_id": "%d"} true`, snapshotCheckpointEvery+2),
+		fmt.Sprintf(`test.empty null true, test.student {"_id": "%d"} true`, snapshotCheckpointEvery+2),
+		"",
+	}
+	if !reflect.DeepEqual(saved, expected) {
+		t.Errorf("Checkpoints mismatch:\nExpected: %q\nActual  : %q", expected, saved)
+	}
+}
+
+// runBatch feeds entries to the batching of processor, each as its own
+// reader message, and returns the batches it produced.
+func runBatch(t *testing.T, processor *OpLogProcessor, entries []string) []ports.Batch {
+	t.Helper()
+	oplogChan := make(chan string, len(entries))
+	for _, entry := range entries {
+		oplogChan <- "[" + strings.TrimSpace(entry) + "]"
+	}
+	close(oplogChan)
+
+	batchChan := make(chan ports.Batch)
+	go func() {
+		defer close(batchChan)
+		processor.batch(context.Background(), oplogChan, batchChan)
+	}()
+
+	var batches []ports.Batch
+	for batch := range batchChan {
+		batches = append(batches, batch)
+	}
+	return batches
+}
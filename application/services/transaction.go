@@ -0,0 +1,102 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"op-log-parser/application/domain/models"
+)
+
+const (
+	commandOperation       = "c"
+	noopOperation          = "n"
+	fieldApplyOps          = "applyOps"
+	fieldPartialTxn        = "partialTxn"
+	fieldPrepare           = "prepare"
+	fieldCommitTransaction = "commitTransaction"
+	fieldAbortTransaction  = "abortTransaction"
+)
+
+// transactionStep is the part an oplog entry plays in a multi-document
+// transaction.
+type transactionStep int
+
+const (
+	// stepNone is an entry outside of applyOps transactions.
+	stepNone transactionStep = iota
+	// stepApply is an applyOps entry completing its transaction.
+	stepApply
+	// stepPartial is an applyOps entry of a transaction continued by later
+	// entries: a partial one, or the last one of a prepared transaction.
+	stepPartial
+	// stepCommit and stepAbort end a prepared transaction.
+	stepCommit
+	stepAbort
+)
+
+// transactionStepOf classifies opLog. Large transactions are written as
+// several applyOps entries, all but the last marked partialTxn. Prepared
+// transactions end with an applyOps marked prepare and only take effect at
+// the later commitTransaction entry, or never after abortTransaction.
+func transactionStepOf(opLog models.OpLog) transactionStep {
+	if opLog.Operation != commandOperation {
+		return stepNone
+	}
+	switch {
+	case opLog.Data[fieldCommitTransaction] != nil:
+		return stepCommit
+	case opLog.Data[fieldAbortTransaction] != nil:
+		return stepAbort
+	case opLog.Data[fieldApplyOps] == nil:
+		return stepNone
+	case opLog.Data[fieldPartialTxn] == true, opLog.Data[fieldPrepare] == true:
+		return stepPartial
+	default:
+		return stepApply
+	}
+}
+
+// transactionKey identifies the session transaction an entry belongs to.
+// Entries outside a transaction return an empty key.
+func transactionKey(opLog models.OpLog) string {
+	if opLog.LSID == nil || opLog.TxnNumber == nil {
+		return ""
+	}
+	return fmt.Sprintf("%s:%d", opLog.LSID.ID, *opLog.TxnNumber)
+}
+
+// expandApplyOps replaces an applyOps command, as written for multi-document
+// transactions, by the operations it contains. They inherit the ts, wall and
+// session of the command. Other entries are returned as they are.
+func expandApplyOps(opLog models.OpLog) ([]models.OpLog, error) {
+	if opLog.Operation != commandOperation {
+		return []models.OpLog{opLog}, nil
+	}
+	applyOps, ok := opLog.Data[fieldApplyOps]
+	if !ok {
+		return []models.OpLog{opLog}, nil
+	}
+
+	encoded, err := json.Marshal(applyOps)
+	if err != nil {
+		return nil, fmt.Errorf("encoding applyOps: %v", err)
+	}
+	var opLogs []models.OpLog
+	if err := json.Unmarshal(encoded, &opLogs); err != nil {
+		return nil, fmt.Errorf("decoding applyOps: %v", err)
+	}
+	for i := range opLogs {
+		if opLogs[i].Timestamp.IsZero() {
+			opLogs[i].Timestamp = opLog.Timestamp
+		}
+		if opLogs[i].Wall.IsZero() {
+			opLogs[i].Wall = opLog.Wall
+		}
+		if opLogs[i].LSID == nil {
+			opLogs[i].LSID = opLog.LSID
+		}
+		if opLogs[i].TxnNumber == nil {
+			opLogs[i].TxnNumber = opLog.TxnNumber
+		}
+	}
+	return opLogs, nil
+}
@@ -0,0 +1,121 @@
+package services
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"op-log-parser/application/domain/models"
+)
+
+func TestTransactionKey(t *testing.T) {
+	txnNumber := int64(3)
+	session := &models.SessionID{ID: models.Binary{Subtype: 4, Data: make([]byte, 16)}}
+	testCases := []struct {
+		name     string
+		opLog    models.OpLog
+		expected string
+	}{
+		{name: "session transaction", opLog: models.OpLog{LSID: session, TxnNumber: &txnNumber}, expected: "00000000-0000-0000-0000-000000000000:3"},
+		{name: "session without txnNumber", opLog: models.OpLog{LSID: session}},
+		{name: "txnNumber without session", opLog: models.OpLog{TxnNumber: &txnNumber}},
+		{name: "no session", opLog: models.OpLog{}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if actual := transactionKey(tc.opLog); actual != tc.expected {
+				t.Errorf("Expected %q, got %q", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestExpandApplyOps(t *testing.T) {
+	testCases := []struct {
+		name        string
+		inputJSON   string
+		expected    []models.OpLog
+		expectedErr bool
+	}{
+		{
+			name:      "plain entries are returned as they are",
+			inputJSON: `{"op": "i", "ns": "test.student", "o": {"_id": "1"}}`,
+			expected:  []models.OpLog{{Operation: "i", Namespace: "test.student", Data: map[string]any{"_id": "1"}}},
+		},
+		{
+			name:      "commands without applyOps are returned as they are",
+			inputJSON: `{"op": "c", "ns": "test.$cmd", "o": {"create": "student"}}`,
+			expected:  []models.OpLog{{Operation: "c", Namespace: "test.$cmd", Data: map[string]any{"create": "student"}}},
+		},
+		{
+			name: "operations inherit the ts, wall and session of the command",
+			inputJSON: `{
+				"op": "c", "ns": "admin.$cmd", "ts": {"T": 10, "I": 1}, "wall": "2023-06-02T06:28:49Z", "txnNumber": 3,
+				"o": {"applyOps": [
+					{"op": "i", "ns": "test.student", "o": {"_id": "1"}},
+					{"op": "d", "ns": "test.student", "o": {"_id": "2"}, "ts": {"T": 11, "I": 2}}
+				]}
+			}`,
+			expected: func() []models.OpLog {
+				txnNumber := int64(3)
+				wall := models.WallTime{Time: mustParseTime("2023-06-02T06:28:49Z")}
+				return []models.OpLog{
+					{Operation: "i", Namespace: "test.student", Data: map[string]any{"_id": "1"}, Timestamp: models.Timestamp{T: 10, I: 1}, Wall: wall, TxnNumber: &txnNumber},
+					{Operation: "d", Namespace: "test.student", Data: map[string]any{"_id": "2"}, Timestamp: models.Timestamp{T: 11, I: 2}, Wall: wall, TxnNumber: &txnNumber},
+				}
+			}(),
+		},
+		{
+			name:        "malformed applyOps",
+			inputJSON:   `{"op": "c", "ns": "admin.$cmd", "o": {"applyOps": "nope"}}`,
+			expectedErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var opLog models.OpLog
+			if err := json.Unmarshal([]byte(tc.inputJSON), &opLog); err != nil {
+				t.Fatalf("Did not expect an error, but got: %v", err)
+			}
+			actual, err := expandApplyOps(opLog)
+			if tc.expectedErr {
+				if err == nil {
+					t.Errorf("Expected an error, got %v", actual)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Did not expect an error, but got: %v", err)
+			}
+			if !reflect.DeepEqual(actual, tc.expected) {
+				t.Errorf("Mismatch:\nExpected: %+v\nActual  : %+v", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestTransactionStepOf(t *testing.T) {
+	testCases := []struct {
+		name     string
+		opLog    models.OpLog
+		expected transactionStep
+	}{
+		{name: "insert", opLog: models.OpLog{Operation: "i"}, expected: stepNone},
+		{name: "other command", opLog: models.OpLog{Operation: "c", Data: map[string]any{"create": "student"}}, expected: stepNone},
+		{name: "applyOps", opLog: models.OpLog{Operation: "c", Data: map[string]any{"applyOps": []any{}}}, expected: stepApply},
+		{name: "partial applyOps", opLog: models.OpLog{Operation: "c", Data: map[string]any{"applyOps": []any{}, "partialTxn": true}}, expected: stepPartial},
+		{name: "prepared applyOps", opLog: models.OpLog{Operation: "c", Data: map[string]any{"applyOps": []any{}, "prepare": true}}, expected: stepPartial},
+		{name: "commit", opLog: models.OpLog{Operation: "c", Data: map[string]any{"commitTransaction": 1.0}}, expected: stepCommit},
+		{name: "abort", opLog: models.OpLog{Operation: "c", Data: map[string]any{"abortTransaction": 1.0}}, expected: stepAbort},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if actual := transactionStepOf(tc.opLog); actual != tc.expected {
+				t.Errorf("Expected %d, got %d", tc.expected, actual)
+			}
+		})
+	}
+}